@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+// cookiesImport loads session from cookies.txt if it's logged in
+func cookiesImport(ctx context.Context, client *fa.Client) error {
+	f, err := os.Open(opts.Cookies.Import.Args.File)
+	if err != nil {
+		fa.Log.Error("Failed to open cookies file", fa.Fields{"file": opts.Cookies.Import.Args.File, "error": err})
+		return err
+	}
+	defer f.Close()
+	user, n, err := client.ImportCookies(ctx, f)
+	if err != nil {
+		fa.Log.Error("Failed to import cookies, kept previous session", fa.Fields{"file": opts.Cookies.Import.Args.File, "error": err})
+		return err
+	}
+	fa.Log.Info("Imported cookies, logged in", fa.Fields{"cookies": n, "user": user})
+	return nil
+}
+
+// cookiesExport writes cookie jar as cookies.txt to file, or stdout
+func cookiesExport(client *fa.Client) error {
+	var w io.Writer = os.Stdout
+	var f *os.File
+	file := opts.Cookies.Export.Args.File
+	if file != "" && file != "-" {
+		var err error
+		f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fa.Log.Error("Failed to create cookies file", fa.Fields{"file": file, "error": err})
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	err := client.ExportCookies(w)
+	if err != nil {
+		fa.Log.Error("Failed to export cookies", fa.Fields{"error": err})
+		return err
+	}
+	if f != nil {
+		err = f.Close()
+		if err != nil {
+			fa.Log.Error("Failed to write cookies file", fa.Fields{"file": file, "error": err})
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+func printPendingMigrations() error {
+	pending, err := fa.PendingMigrations(opts.ConfigDir)
+	if err != nil {
+		fa.Log.Error("Failed to check database migrations", fa.Fields{"error": err})
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Printf("Database is up to date\n")
+		return nil
+	}
+	fmt.Printf("Pending database migrations:\n")
+	for _, step := range pending {
+		fmt.Printf("  %s\n", step)
+	}
+	return nil
+}
+
+// dbStats prints what's in the database
+func dbStats(client *fa.Client) error {
+	stats, err := client.Store.Stats()
+	if err != nil {
+		fa.Log.Error("Failed to get database stats", fa.Fields{"error": err})
+		return err
+	}
+	fmt.Printf("%d images (%d bytes), %d submissions with metadata, %d failures waiting for retry, %d given up\n",
+		stats.Images, stats.Bytes, stats.Submissions, stats.Failures, stats.GivenUp)
+	return nil
+}
+
+// dbFailures lists images that failed and will be retried on the next run,
+// together with ones given up on
+func dbFailures(client *fa.Client) error {
+	failures, err := client.Store.Failures()
+	if err != nil {
+		fa.Log.Error("Failed to get failures from database", fa.Fields{"error": err})
+		return err
+	}
+	for _, failure := range failures {
+		state := "retry"
+		if failure.GivenUp {
+			state = "given up"
+		}
+		fmt.Printf("%s %-8s %-8s %3d attempts  %s: %s\n", failure.FailedAt.Format("2006-01-02 15:04"), failure.Stage, state, failure.Attempts, failure.PageURL, failure.Error)
+	}
+	fmt.Printf("%d failures\n", len(failures))
+	return nil
+}
+
+// dbForget makes submissions count as not downloaded, so that they're
+// downloaded again, and drops their failures. It goes on past submissions
+// it fails to forget, but returns an error for them.
+func dbForget(client *fa.Client, pages []string) error {
+	failed := 0
+	for _, page := range pages {
+		pageURL, err := url.Parse(page)
+		if err != nil {
+			fa.Log.Error("Failed to parse URL", fa.Fields{"url": page, "error": err})
+			failed++
+			continue
+		}
+		if pageURL.Host == "" {
+			pageURL, _ = url.Parse(fa.URLbase + pageURL.Path)
+		}
+		err = client.Store.DeleteImage(pageURL)
+		if err == nil {
+			err = client.Store.ClearFailure(pageURL)
+		}
+		if err != nil {
+			fa.Log.Error("Failed to forget submission", fa.PageFields(pageURL).With(fa.Fields{"error": err}))
+			failed++
+			continue
+		}
+		fa.Log.Info("Forgot submission", fa.PageFields(pageURL))
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to forget %d of %d submissions", failed, len(pages))
+	}
+	return nil
+}
+
+// dbSources lists submissions found on artist's pages, and whether they're
+// downloaded
+func dbSources(client *fa.Client, artist string, pageType string) error {
+	sources, err := client.Store.SourcesByArtist(artist, pageType)
+	if err != nil {
+		fa.Log.Error("Failed to get sources from database", fa.Fields{"error": err})
+		return err
+	}
+	for _, source := range sources {
+		filename := source.Filename
+		if filename == "" {
+			filename = "(not downloaded)"
+		}
+		fmt.Printf("%s %-9s %s %s\n", source.FirstSeen.Format("2006-01-02 15:04"), source.PageType, source.PageURL, filename)
+	}
+	fmt.Printf("%d submissions\n", len(sources))
+	return nil
+}
+
+// dbArtists lists how scanning of every artist's page type went last time
+func dbArtists(client *fa.Client) error {
+	states, err := client.Store.ScanStates()
+	if err != nil {
+		fa.Log.Error("Failed to get scan states from database", fa.Fields{"error": err})
+		return err
+	}
+	for _, state := range states {
+		status := "incomplete"
+		if state.Completed {
+			status = "completed"
+		}
+		lastCompleted := "never"
+		if !state.LastCompleted.IsZero() {
+			lastCompleted = state.LastCompleted.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%-24s %-9s %-10s last completed %-16s highest ID %d\n", state.Artist, state.PageType, status, lastCompleted, state.HighestID)
+	}
+	fmt.Printf("%d artist pages\n", len(states))
+	return nil
+}
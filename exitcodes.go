@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+// ErrVerifyFailed means verify found downloaded images missing or changed
+var ErrVerifyFailed = errors.New("Verify found problems")
+
+// Exit codes, so that scheduled runs can tell what happened:
+//
+//	0   everything went fine, or there was nothing new
+//	1   something else went wrong
+//	2   some images failed, they'll be retried on the next run
+//	3   not logged in, cookies need refreshing
+//	4   artist doesn't exist
+//	5   artist's account is disabled
+//	6   FurAffinity is down for maintenance
+//	7   FurAffinity answered with some other system message
+//	8   FurAffinity kept rate limiting us
+//	9   verify found images missing or changed
+//	64  bad command line or config file
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitPartialFailure  = 2
+	exitNotLoggedIn     = 3
+	exitArtistNotFound  = 4
+	exitAccountDisabled = 5
+	exitMaintenance     = 6
+	exitSystemMessage   = 7
+	exitRateLimited     = 8
+	exitVerifyFailed    = 9
+	exitUsage           = 64
+)
+
+// exitCode picks exit code for error a command returned
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, fa.ErrPartialFailure):
+		return exitPartialFailure
+	case errors.Is(err, ErrVerifyFailed):
+		return exitVerifyFailed
+	case errors.Is(err, fa.ErrNotLoggedIn):
+		return exitNotLoggedIn
+	case errors.Is(err, fa.ErrArtistNotFound):
+		return exitArtistNotFound
+	case errors.Is(err, fa.ErrRateLimited):
+		return exitRateLimited
+	}
+	var pageErr *fa.PageError
+	if errors.As(err, &pageErr) {
+		switch pageErr.Class {
+		case fa.PageAccountDisabled:
+			return exitAccountDisabled
+		case fa.PageMaintenance:
+			return exitMaintenance
+		case fa.PageSystemMessage:
+			return exitSystemMessage
+		}
+	}
+	return exitError
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+// export writes metadata of all downloaded images to --output, or stdout
+func export(client *fa.Client) error {
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if opts.Export.Output != "" && opts.Export.Output != "-" {
+		var err error
+		f, err = os.Create(opts.Export.Output)
+		if err != nil {
+			fa.Log.Error("Failed to create export file", fa.Fields{"file": opts.Export.Output, "error": err})
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	err := client.Store.Export(w, opts.Export.Format)
+	if err != nil {
+		fa.Log.Error("Failed to export", fa.Fields{"error": err})
+		return err
+	}
+	if f != nil {
+		err = f.Close()
+		if err != nil {
+			fa.Log.Error("Failed to write export file", fa.Fields{"file": opts.Export.Output, "error": err})
+			return err
+		}
+	}
+	return nil
+}
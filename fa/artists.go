@@ -0,0 +1,96 @@
+package fa
+
+import (
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// ScanState is how scanning of artist's gallery, scraps or favorites went
+// last time
+type ScanState struct {
+	Artist   string
+	PageType string
+	// StartedAt is when the last scan started
+	StartedAt time.Time
+	// LastCompleted is when a scan last completed, zero if none ever did
+	LastCompleted time.Time
+	// HighestID is the highest submission ID seen by completed scans
+	HighestID int64
+	// Completed is set when the last scan saw every page it needed to and
+	// downloaded every image it found
+	Completed bool
+}
+
+// ScanState returns how artist's pageType was scanned last time, nil if it
+// never was
+func (s *Store) ScanState(artist string, pageType string) (*ScanState, error) {
+	states, err := s.scanStates("WHERE artist = ? AND page_type = ?", strings.ToLower(artist), pageType)
+	if err != nil || len(states) == 0 {
+		return nil, err
+	}
+	return states[0], nil
+}
+
+// ScanStates returns scan states of all artists
+func (s *Store) ScanStates() ([]*ScanState, error) {
+	return s.scanStates("ORDER BY artist, page_type")
+}
+
+func (s *Store) scanStates(where string, args ...interface{}) ([]*ScanState, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	states := []*ScanState{}
+	fn := func(stmt *sqlite.Stmt) error {
+		state := &ScanState{
+			Artist:    stmt.ColumnText(0),
+			PageType:  stmt.ColumnText(1),
+			StartedAt: time.Unix(stmt.ColumnInt64(2), 0),
+			HighestID: stmt.ColumnInt64(4),
+			Completed: stmt.ColumnInt(5) != 0,
+		}
+		if stmt.ColumnType(3) != sqlite.SQLITE_NULL {
+			state.LastCompleted = time.Unix(stmt.ColumnInt64(3), 0)
+		}
+		states = append(states, state)
+		return nil
+	}
+	err = sqlitex.Exec(db, "SELECT artist, page_type, started_at, last_completed, highest_id, completed FROM artists "+where, fn, args...)
+	if err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// StartScan records that scanning of artist's pageType started, so it
+// doesn't count as completed until FinishScan
+func (s *Store) StartScan(artist string, pageType string) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	return sqlitex.Exec(db, `INSERT INTO artists (artist, page_type, started_at, highest_id, completed) VALUES (?, ?, ?, 0, 0)
+		ON CONFLICT(artist, page_type) DO UPDATE SET started_at = excluded.started_at, completed = 0`,
+		nil, strings.ToLower(artist), pageType, time.Now().Unix())
+}
+
+// FinishScan records that scan of artist's pageType completed, having seen
+// submissions up to highestID
+func (s *Store) FinishScan(artist string, pageType string, highestID int64) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	return sqlitex.Exec(db, "UPDATE artists SET completed = 1, last_completed = ?, highest_id = max(highest_id, ?) WHERE artist = ? AND page_type = ?",
+		nil, time.Now().Unix(), highestID, strings.ToLower(artist), pageType)
+}
@@ -0,0 +1,41 @@
+package fa
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// hashFile returns hex encoded SHA-256 and size of file's contents
+func hashFile(filepath string) (string, int64, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("Failed to read %s: %w", filepath, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// hashPrefix feeds first n bytes of file into h
+func hashPrefix(h hash.Hash, filepath string, n int64) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(h, f, n)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %w", filepath, err)
+	}
+	return nil
+}
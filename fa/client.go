@@ -0,0 +1,363 @@
+// Package fa implements a client for scanning FurAffinity galleries and
+// downloading submissions into a local archive.
+package fa
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/headzoo/surf"
+	"github.com/headzoo/surf/browser"
+	"github.com/juju/persistent-cookiejar"
+	"go.uber.org/ratelimit"
+)
+
+// URLbase is the root of all FurAffinity pages
+const URLbase = "https://www.furaffinity.net"
+
+const userAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_3) AppleWebKit/536.28.10 (KHTML, like Gecko) Version/6.0.3 Safari/536.28.10"
+
+// Options configures a Client
+type Options struct {
+	// ConfigDir holds cookies.json and downloaded.sqlite
+	ConfigDir string
+	// DownloadDirectory is where images are saved to
+	DownloadDirectory string
+	// CDNRate limits requests per second to the image server, separately
+	// from page loads. Defaults to 10.
+	CDNRate int
+	// Retry is used for page loads and image requests. Defaults to
+	// DefaultRetryPolicy.
+	Retry RetryPolicy
+	// Sidecar selects metadata file written next to every image: empty for
+	// none or SidecarJSON
+	Sidecar string
+	// EmbedMetadata writes XMP metadata into downloaded JPEG and PNG files
+	EmbedMetadata bool
+	// Progress is told how downloads are going. Defaults to nothing.
+	Progress Progress
+	// PathTemplate lays out images in download directory. Defaults to
+	// DefaultPathTemplate.
+	PathTemplate string
+	// BaseURL is where FA pages are loaded from. Defaults to URLbase, tests
+	// point it at a local server.
+	BaseURL string
+}
+
+// Client is a logged-in FurAffinity session together with the database of
+// already downloaded images.
+type Client struct {
+	Browser *browser.Browser
+	Jar     *cookiejar.Jar
+	Store   *Store
+
+	options   Options
+	root      string
+	rl        ratelimit.Limiter
+	cdnrl     ratelimit.Limiter
+	transport *contextTransport
+	template  *PathTemplate
+	paths     *pathReservations
+}
+
+// Submission is a single /view/ page, the image it links to and what FA
+// says about it
+type Submission struct {
+	ID       int64
+	PageURL  *url.URL
+	ImageURL *url.URL
+	// Artist is how the submission page shows artist's name, Username is
+	// how FA URLs have it, like Tojo-The-Thief and tojo-the-thief
+	Artist   string
+	Username string
+	// PageType is where submission was found: gallery, scraps, favorites
+	// or watchlist. It's empty when that isn't known.
+	PageType string
+
+	Title       string
+	PostedAt    time.Time
+	Category    string
+	Theme       string
+	Species     string
+	Gender      string
+	Rating      string
+	Tags        []string
+	Description string // as HTML
+	Folders     []Folder
+	Views       int
+	Favorites   int
+	Comments    int
+}
+
+// Posted returns when submission was posted, falling back to when its image
+// was uploaded as encoded in the image's filename. It's zero if neither is
+// known.
+func (s *Submission) Posted() time.Time {
+	if !s.PostedAt.IsZero() {
+		return s.PostedAt
+	}
+	if s.ImageURL == nil {
+		return time.Time{}
+	}
+	m := firstTenDigits.FindString(path.Base(s.ImageURL.Path))
+	if len(m) == 0 {
+		return time.Time{}
+	}
+	value, err := strconv.ParseInt(m, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(value, 0)
+}
+
+// NewClient loads the cookie jar and opens the database from
+// options.ConfigDir, migrating it to the current schema. Database can't be
+// used any more once ctx is done.
+func NewClient(ctx context.Context, options Options) (*Client, error) {
+	if options.ConfigDir == "" {
+		return nil, fmt.Errorf("Config directory is empty, that isn't acceptable")
+	}
+	if options.DownloadDirectory == "" {
+		return nil, fmt.Errorf("Download directory is empty, that isn't acceptable")
+	}
+
+	if options.CDNRate <= 0 {
+		options.CDNRate = 10
+	}
+	if options.Retry.MaxAttempts <= 0 {
+		options.Retry = DefaultRetryPolicy
+	}
+	if options.Progress == nil {
+		options.Progress = noProgress{}
+	}
+	if options.BaseURL == "" {
+		options.BaseURL = URLbase
+	}
+	options.BaseURL = strings.TrimSuffix(options.BaseURL, "/")
+	template, err := ParsePathTemplate(options.PathTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if options.Sidecar != "" && options.Sidecar != SidecarJSON {
+		return nil, fmt.Errorf("Unknown sidecar format %s", options.Sidecar)
+	}
+
+	c := &Client{
+		Browser:   surf.NewBrowser(),
+		options:   options,
+		root:      options.DownloadDirectory,
+		rl:        ratelimit.New(3, ratelimit.WithoutSlack),
+		cdnrl:     ratelimit.New(options.CDNRate),
+		transport: &contextTransport{base: http.DefaultTransport},
+		template:  template,
+		paths:     &pathReservations{owners: map[string]string{}},
+	}
+	c.Browser.SetTransport(c.transport)
+
+	err = os.MkdirAll(options.ConfigDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create config directory %s: %w", options.ConfigDir, err)
+	}
+
+	c.Jar, err = cookiejar.New(&cookiejar.Options{
+		Filename: path.Join(options.ConfigDir, "cookies.json"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load cookie jar: %w", err)
+	}
+	c.Browser.SetCookieJar(c.Jar)
+	c.Browser.SetUserAgent(userAgent)
+	err = c.Jar.Save()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to save cookie jar: %w", err)
+	}
+
+	// don't keep unlimited history, we never use the feature anyway
+	c.Browser.HistoryJar().SetMax(1)
+
+	c.Store, err = OpenStore(ctx, path.Join(options.ConfigDir, DatabaseFilename))
+	if err != nil {
+		return nil, err
+	}
+	err = c.Store.Migrate()
+	if err != nil {
+		c.Store.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// BaseURL is the root of pages client loads, URLbase unless configured
+// otherwise
+func (c *Client) BaseURL() string {
+	return c.options.BaseURL
+}
+
+// WithDownloadDirectory returns a client sharing everything with c except
+// that Download saves images into directory. Images saved outside of the
+// original download directory are recorded in database by their full path.
+func (c *Client) WithDownloadDirectory(directory string) *Client {
+	clone := *c
+	clone.options.DownloadDirectory = directory
+	return &clone
+}
+
+// imagePath turns filename stored in database into path of the image
+func (c *Client) imagePath(filename string) string {
+	if path.IsAbs(filename) {
+		return filename
+	}
+	return path.Join(c.root, filename)
+}
+
+// storedFilename is the reverse of imagePath
+func (c *Client) storedFilename(fullpath string) string {
+	if rel, err := filepath.Rel(c.root, fullpath); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return fullpath
+}
+
+// Close saves the cookie jar and closes the database
+func (c *Client) Close() error {
+	jarErr := c.Jar.Save()
+	storeErr := c.Store.Close()
+	if jarErr != nil {
+		return fmt.Errorf("Failed to save cookie jar: %w", jarErr)
+	}
+	return storeErr
+}
+
+// Open loads URL into client's browser, obeying the rate limit and retrying
+// on temporary failures. Pages a logged in user wouldn't get, like system
+// messages or login prompt, are returned as *PageError.
+func (c *Client) Open(ctx context.Context, URL string) error {
+	err := c.load(ctx, URL)
+	if err != nil {
+		return err
+	}
+	class, message := classifyPage(c.Browser.Dom())
+	if class != PageLoggedIn {
+		return &PageError{URL: URL, Class: class, Message: message}
+	}
+	return nil
+}
+
+// load is Open without looking at what the page is
+func (c *Client) load(ctx context.Context, URL string) error {
+	return c.options.Retry.Do(ctx, func() error {
+		c.rl.Take()
+
+		c.transport.use(ctx)
+		err := c.Browser.Open(URL)
+		if err != nil {
+			return err
+		}
+
+		if !isResponseOK(c.Browser.State().Response) {
+			return newStatusError(URL, c.Browser.State().Response)
+		}
+		return nil
+	})
+}
+
+// GalleryPages returns links to submissions found on page number n of
+// artist's gallery, scraps or favorites (as given by pageType)
+func (c *Client) GalleryPages(ctx context.Context, artist string, pageType string, n int) ([]*url.URL, error) {
+	return c.submissionLinks(ctx, fmt.Sprintf("%s/%s/%s/%d/", c.options.BaseURL, pageType, artist, n))
+}
+
+// submissionLinks opens listing page and returns links to submissions on it.
+// If it fails, failure is recorded in the database. Listing pages aren't
+// retried from there, scanning gets to them again.
+func (c *Client) submissionLinks(ctx context.Context, listingPage string) ([]*url.URL, error) {
+	pageURL, err := url.Parse(listingPage)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Open(ctx, listingPage)
+	if err != nil {
+		err = &StageError{Stage: StageGallery, Err: fmt.Errorf("Got error while getting %s: %w", listingPage, err)}
+		c.recordFailure(pageURL, nil, err)
+		return nil, err
+	}
+	err = c.Store.ClearFailure(pageURL)
+	if err != nil {
+		Log.Warn("Failed to clear failure from database", PageFields(pageURL).With(Fields{"error": err}))
+	}
+
+	seen := map[string]bool{}
+	pages := []*url.URL{}
+	for _, link := range c.Browser.Links() {
+		if !strings.Contains(link.URL.Path, "/view/") {
+			continue
+		}
+		if seen[link.URL.String()] {
+			continue
+		}
+		seen[link.URL.String()] = true
+		pages = append(pages, link.URL)
+	}
+	return pages, nil
+}
+
+// Submission opens submission page, finds the image download link on it and
+// stores submission's metadata.
+// If it fails, failure is recorded in the database.
+func (c *Client) Submission(ctx context.Context, pageURL *url.URL) (*Submission, error) {
+	sub, err := c.submission(ctx, pageURL)
+	if err != nil {
+		c.recordFailure(pageURL, nil, err)
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (c *Client) submission(ctx context.Context, pageURL *url.URL) (*Submission, error) {
+	err := c.Open(ctx, pageURL.String())
+	if err != nil {
+		return nil, &StageError{Stage: StagePage, Err: fmt.Errorf("Got error while getting %s: %w", pageURL, err)}
+	}
+
+	sub := &Submission{PageURL: pageURL}
+	parseSubmission(c.Browser.Dom(), sub)
+	for _, link := range c.Browser.Links() {
+		if link.Text == "Download" {
+			sub.ImageURL = link.URL
+		}
+	}
+
+	if sub.ImageURL == nil {
+		return nil, &StageError{Stage: StagePage, Err: fmt.Errorf("Page %s does not have image link (page title is %s)", pageURL, c.Browser.Title())}
+	}
+
+	if sub.ID != 0 {
+		err = c.Store.SetSubmission(sub)
+		if err != nil {
+			return nil, &StageError{Stage: StageDatabase, Err: fmt.Errorf("Failed saving submission metadata: %w", err)}
+		}
+	}
+	return sub, nil
+}
+
+// IsDownloaded checks if submission page was already downloaded
+func (c *Client) IsDownloaded(pageURL *url.URL) (bool, error) {
+	return c.Store.IsDownloaded(pageURL)
+}
+
+func isResponseOK(response *http.Response) bool {
+	switch response.StatusCode {
+	case 200:
+		return true
+	}
+	return false
+}
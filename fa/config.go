@@ -0,0 +1,209 @@
+package fa
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"github.com/mitchellh/go-homedir"
+)
+
+// ConfigFilename is the name of the config file inside config directory
+const ConfigFilename = "config.toml"
+
+// Config is what config file holds besides option defaults
+type Config struct {
+	Artists []*ArtistProfile `toml:"artist"`
+}
+
+// ArtistProfile overrides options for a single artist. Options left out of
+// the profile keep their usual value.
+type ArtistProfile struct {
+	Name              string `toml:"name"`
+	NoFastScan        *bool  `toml:"no-fast-scan"`
+	NoGrabGallery     *bool  `toml:"no-grab-gallery"`
+	GrabFavourites    *bool  `toml:"grab-favourites"`
+	GrabScraps        *bool  `toml:"grab-scraps"`
+	GrabFolders       *bool  `toml:"grab-folders"`
+	DownloadDirectory string `toml:"download-directory"`
+	Since             string `toml:"since"`
+}
+
+// Profile finds artist's profile, comparing names the way FA URLs have
+// them, so Some_Artist is someartist. It returns nil if artist has none.
+func (c *Config) Profile(artist string) *ArtistProfile {
+	for _, profile := range c.Artists {
+		if NormalizeUsername(profile.Name) == NormalizeUsername(artist) {
+			return profile
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads config.toml from configDir. Its top level keys are long
+// option names of parser and become their defaults, so command line still
+// wins. Boolean options can't be turned off on command line, so they aren't
+// allowed there, scan options belong in profiles instead. [[artist]] tables
+// are returned as profiles. Missing file is the same as an empty one.
+func LoadConfig(parser *flags.Parser, configDir string) (*Config, error) {
+	config := &Config{}
+	configpath := path.Join(configDir, ConfigFilename)
+	if _, err := os.Stat(configpath); os.IsNotExist(err) {
+		return config, nil
+	}
+
+	md, err := toml.DecodeFile(configpath, config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", configpath, err)
+	}
+	for _, key := range md.Undecoded() {
+		if len(key) > 1 && key[0] == "artist" {
+			return nil, fmt.Errorf("Unknown artist option %s in %s", key[len(key)-1], configpath)
+		}
+	}
+	for i, profile := range config.Artists {
+		if profile.Name == "" {
+			return nil, fmt.Errorf("Artist #%d in %s has no name", i+1, configpath)
+		}
+		if profile.DownloadDirectory != "" {
+			profile.DownloadDirectory, err = homedir.Expand(profile.DownloadDirectory)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if profile.Since != "" {
+			_, err = ParseSince(profile.Since)
+			if err != nil {
+				return nil, fmt.Errorf("Bad since of artist %s in %s: %w", profile.Name, configpath, err)
+			}
+		}
+	}
+
+	var defaults map[string]interface{}
+	_, err = toml.DecodeFile(configpath, &defaults)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %w", configpath, err)
+	}
+	delete(defaults, "artist")
+
+	// sorted, so that errors are the same every time
+	keys := make([]string, 0, len(defaults))
+	for key := range defaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err = setDefault(parser, key, defaults[key])
+		if err != nil {
+			return nil, fmt.Errorf("Bad option %s in %s: %w", key, configpath, err)
+		}
+	}
+	return config, nil
+}
+
+// setDefault makes value the default of options with long name key, in
+// every command that has one
+func setDefault(parser *flags.Parser, key string, value interface{}) error {
+	options := findOptions(parser.Command, key)
+	if len(options) == 0 || key == "config-directory" {
+		return fmt.Errorf("No such option")
+	}
+	for _, option := range options {
+		if option.Field().Type.Kind() == reflect.Bool {
+			return fmt.Errorf("Boolean options can't be set in config file as command line couldn't turn them off, use command line or [[artist]] profiles")
+		}
+	}
+
+	values := []interface{}{value}
+	if list, ok := value.([]interface{}); ok {
+		values = list
+	}
+	defaults := make([]string, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			defaults = append(defaults, v)
+		case bool:
+			defaults = append(defaults, strconv.FormatBool(v))
+		case int64:
+			defaults = append(defaults, strconv.FormatInt(v, 10))
+		case float64:
+			defaults = append(defaults, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return fmt.Errorf("Unsupported value %v", v)
+		}
+	}
+
+	mask := ""
+	if key == "download-directory" && len(defaults) == 1 {
+		expanded, err := homedir.Expand(defaults[0])
+		if err != nil {
+			return err
+		}
+		mask = defaults[0]
+		defaults[0] = expanded
+	}
+
+	for _, option := range options {
+		if len(option.Choices) > 0 {
+			for _, d := range defaults {
+				if !contains(option.Choices, d) {
+					return fmt.Errorf("%s isn't one of %s", d, strings.Join(option.Choices, ", "))
+				}
+			}
+		}
+		option.DefaultMask = mask
+		option.Default = defaults
+	}
+	return nil
+}
+
+// ParseSince accepts either a date or a full RFC3339 timestamp
+func ParseSince(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("Couldn't parse %s, expected YYYY-MM-DD or RFC3339 time", value)
+	}
+	return t, nil
+}
+
+// findOptions collects options with long name key from command, its groups
+// and subcommands
+func findOptions(command *flags.Command, key string) []*flags.Option {
+	options := []*flags.Option{}
+	var walk func(group *flags.Group)
+	walk = func(group *flags.Group) {
+		for _, option := range group.Options() {
+			if option.LongName == key {
+				options = append(options, option)
+			}
+		}
+		for _, g := range group.Groups() {
+			walk(g)
+		}
+	}
+	walk(command.Group)
+	for _, c := range command.Commands() {
+		options = append(options, findOptions(c, key)...)
+	}
+	return options
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package fa
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestLoadConfig(t *testing.T) {
+	var opts struct {
+		Workers    int    `long:"workers" default:"4"`
+		NoFastScan bool   `long:"no-fast-scan"`
+		Since      string `long:"since"`
+	}
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"workers = 8\n[[artist]]\nname = \"Some_Artist\"\nno-fast-scan = true\nsince = \"2020-09-01\"\n", ""},
+		{"no-fast-scan = true\n", "Boolean options"},
+		{"[[artist]]\nname = \"someone\"\nsince = \"2020-13-01\"\n", "Bad since of artist someone"},
+		{"no-such-option = 1\n", "No such option"},
+	}
+	for _, test := range tests {
+		dir := tempDir(t)
+		err := ioutil.WriteFile(path.Join(dir, ConfigFilename), []byte(test.config), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(flags.NewParser(&opts, flags.Default), dir)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("LoadConfig(%q) failed: %v", test.config, err)
+			} else if config.Profile("someartist") == nil {
+				t.Errorf("LoadConfig(%q) profile isn't found by username", test.config)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("LoadConfig(%q) = %v, want error containing %q", test.config, err, test.err)
+		}
+	}
+}
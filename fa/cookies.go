@@ -0,0 +1,154 @@
+package fa
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCookies are the cookies FA keeps login session in
+var sessionCookies = map[string]bool{"a": true, "b": true}
+
+const cookieDomain = "furaffinity.net"
+
+// ImportCookies loads FA session cookies from Netscape cookies.txt format,
+// as exported by browser extensions, into the cookie jar. Jar is only saved
+// once the imported session turns out to be logged in, otherwise the
+// session it had before is put back. It returns whose session it is and
+// how many cookies were imported.
+func (c *Client) ImportCookies(ctx context.Context, r io.Reader) (string, int, error) {
+	cookies, err := parseCookiesTxt(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	u, _ := url.Parse(URLbase)
+	previous := c.sessionCookies()
+	c.Jar.SetCookies(u, cookies)
+	user, err := c.LoggedInUser(ctx)
+	if err != nil {
+		c.restoreCookies(u, cookies, previous)
+		return "", 0, fmt.Errorf("Imported session isn't logged in: %w", err)
+	}
+	err = c.Jar.Save()
+	if err != nil {
+		return "", 0, fmt.Errorf("Failed to save cookie jar: %w", err)
+	}
+	return user, len(cookies), nil
+}
+
+// sessionCookies returns copies of FA session cookies in the jar
+func (c *Client) sessionCookies() []*http.Cookie {
+	cookies := []*http.Cookie{}
+	for _, cookie := range c.Jar.AllCookies() {
+		if sessionCookies[cookie.Name] && isCookieDomain(cookie.Domain) {
+			copied := *cookie
+			cookies = append(cookies, &copied)
+		}
+	}
+	return cookies
+}
+
+// restoreCookies puts previous session cookies back in place of imported
+// ones, dropping imported cookies that weren't there before
+func (c *Client) restoreCookies(u *url.URL, imported []*http.Cookie, previous []*http.Cookie) {
+	for _, cookie := range imported {
+		dropped := *cookie
+		dropped.MaxAge = -1
+		c.Jar.SetCookies(u, []*http.Cookie{&dropped})
+	}
+	c.Jar.SetCookies(u, previous)
+}
+
+// parseCookiesTxt reads FA session cookies from Netscape cookies.txt
+// format. Cookies of other sites and other FA cookies are left out.
+func parseCookiesTxt(r io.Reader) ([]*http.Cookie, error) {
+	cookies := []*http.Cookie{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expires, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("Line %d isn't in cookies.txt format, expected 7 tab separated fields", n)
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		if !isCookieDomain(domain) {
+			continue
+		}
+		if !sessionCookies[fields[5]] {
+			continue
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d has bad expiry time %s: %w", n, fields[4], err)
+		}
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+			Expires:  time.Unix(expires, 0),
+		}
+		// session cookies aren't saved by the jar, keep them for a year
+		if expires == 0 {
+			cookie.Expires = time.Now().AddDate(1, 0, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read cookies: %w", err)
+	}
+	if len(cookies) == 0 {
+		return nil, fmt.Errorf("No FurAffinity session cookies found")
+	}
+	return cookies, nil
+}
+
+// ExportCookies writes FA cookies from the cookie jar in Netscape
+// cookies.txt format, which curl and friends understand
+func (c *Client) ExportCookies(w io.Writer) error {
+	return writeCookiesTxt(w, c.Jar.AllCookies())
+}
+
+// writeCookiesTxt writes FA cookies among cookies in Netscape cookies.txt
+// format
+func writeCookiesTxt(w io.Writer, cookies []*http.Cookie) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "# Netscape HTTP Cookie File\n")
+	for _, cookie := range cookies {
+		if !isCookieDomain(cookie.Domain) {
+			continue
+		}
+		// jar doesn't tell host-only cookies apart, FA only sets domain ones
+		domain := "." + cookie.Domain
+		if cookie.HttpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		secure := "FALSE"
+		if cookie.Secure {
+			secure = "TRUE"
+		}
+		fmt.Fprintf(b, "%s\tTRUE\t%s\t%s\t%d\t%s\t%s\n", domain, cookie.Path, secure, cookie.Expires.Unix(), cookie.Name, cookie.Value)
+	}
+	return b.Flush()
+}
+
+// isCookieDomain tells if cookie domain is FA's
+func isCookieDomain(domain string) bool {
+	return domain == cookieDomain || strings.HasSuffix(domain, "."+cookieDomain)
+}
@@ -0,0 +1,124 @@
+package fa
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCookiesTxt(t *testing.T) {
+	year := time.Now().AddDate(1, 0, 0)
+	tests := []struct {
+		name  string
+		input string
+		want  []*http.Cookie
+		err   string
+	}{
+		{
+			name: "session cookies",
+			input: "# Netscape HTTP Cookie File\n\n" +
+				".furaffinity.net\tTRUE\t/\tTRUE\t1700000000\ta\tvalue-a\n" +
+				"#HttpOnly_.furaffinity.net\tTRUE\t/\tFALSE\t1700000000\tb\tvalue-b\n",
+			want: []*http.Cookie{
+				{Name: "a", Value: "value-a", Domain: "furaffinity.net", Path: "/", Secure: true, Expires: time.Unix(1700000000, 0)},
+				{Name: "b", Value: "value-b", Domain: "furaffinity.net", Path: "/", HttpOnly: true, Expires: time.Unix(1700000000, 0)},
+			},
+		},
+		{
+			name: "other sites and cookies are left out",
+			input: ".example.com\tTRUE\t/\tFALSE\t1700000000\ta\tnot-fa\n" +
+				"notfuraffinity.net\tFALSE\t/\tFALSE\t1700000000\ta\tnot-fa\n" +
+				"www.furaffinity.net\tFALSE\t/\tFALSE\t1700000000\tsfw\t1\n" +
+				"www.furaffinity.net\tFALSE\t/\tFALSE\t1700000000\ta\tvalue-a\n",
+			want: []*http.Cookie{
+				{Name: "a", Value: "value-a", Domain: "www.furaffinity.net", Path: "/", Expires: time.Unix(1700000000, 0)},
+			},
+		},
+		{
+			name:  "browser session cookie is kept for a year",
+			input: ".furaffinity.net\tTRUE\t/\tFALSE\t0\ta\tvalue-a\n",
+			want: []*http.Cookie{
+				{Name: "a", Value: "value-a", Domain: "furaffinity.net", Path: "/", Expires: year},
+			},
+		},
+		{name: "wrong field count", input: ".furaffinity.net\tTRUE\t/\tFALSE\t0\ta\n", err: "Line 1 isn't in cookies.txt format"},
+		{name: "bad expiry", input: "\n.furaffinity.net\tTRUE\t/\tFALSE\tnever\ta\tvalue-a\n", err: "Line 2 has bad expiry time never"},
+		{name: "no session cookies", input: "# Netscape HTTP Cookie File\n", err: "No FurAffinity session cookies found"},
+	}
+	for _, test := range tests {
+		got, err := parseCookiesTxt(strings.NewReader(test.input))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		// session cookies expire a year from whenever they were parsed
+		for _, cookie := range got {
+			if cookie.Expires.Sub(year) > -time.Minute && cookie.Expires.Sub(year) < time.Minute {
+				cookie.Expires = year
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteCookiesTxt(t *testing.T) {
+	cookies := []*http.Cookie{
+		{Name: "a", Value: "value-a", Domain: "furaffinity.net", Path: "/", Secure: true, HttpOnly: true, Expires: time.Unix(1700000000, 0)},
+		{Name: "b", Value: "value-b", Domain: "www.furaffinity.net", Path: "/", Expires: time.Unix(1700000000, 0)},
+		{Name: "a", Value: "not-fa", Domain: "example.com", Path: "/", Expires: time.Unix(1700000000, 0)},
+	}
+	var b bytes.Buffer
+	err := writeCookiesTxt(&b, cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# Netscape HTTP Cookie File\n" +
+		"#HttpOnly_.furaffinity.net\tTRUE\t/\tTRUE\t1700000000\ta\tvalue-a\n" +
+		".www.furaffinity.net\tTRUE\t/\tFALSE\t1700000000\tb\tvalue-b\n"
+	if b.String() != want {
+		t.Errorf("Got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	// what's written reads back the same
+	got, err := parseCookiesTxt(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cookies[:2]) {
+		t.Errorf("Read back %+v, want %+v", got, cookies[:2])
+	}
+}
+
+func TestImportCookiesKeepsSessionWhenLoggedOut(t *testing.T) {
+	server := loginServer(t)
+	client := testClient(t, tempDir(t), server.URL)
+	u, _ := url.Parse(URLbase)
+	client.Jar.SetCookies(u, []*http.Cookie{{Name: "a", Value: "working", Domain: "furaffinity.net", Path: "/", Expires: time.Now().AddDate(1, 0, 0)}})
+
+	// mock server never gets FA's cookies, so it's logged out
+	_, _, err := client.ImportCookies(context.Background(), strings.NewReader(
+		".furaffinity.net\tTRUE\t/\tFALSE\t0\ta\texpired\n.furaffinity.net\tTRUE\t/\tFALSE\t0\tb\texpired\n"))
+	if err == nil {
+		t.Fatal("Import of logged out session succeeded")
+	}
+	got := map[string]string{}
+	for _, cookie := range client.sessionCookies() {
+		got[cookie.Name] = cookie.Value
+	}
+	if want := map[string]string{"a": "working"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got session cookies %v after failed import, want %v", got, want)
+	}
+}
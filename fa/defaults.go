@@ -0,0 +1,56 @@
+package fa
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/kirsle/configdir"
+	"github.com/mitchellh/go-homedir"
+)
+
+// UpdateDefaults fills in platform/user specific defaults for the
+// download-directory and config-directory options of parser
+func UpdateDefaults(parser *flags.Parser) error {
+	// expand ~ into home directory
+	err := expandDefaultDownloadDirectory(parser)
+	if err != nil {
+		return err
+	}
+	// set config directory to platform standard
+	return setDefaultConfigDirectory(parser)
+}
+
+func expandDefaultDownloadDirectory(parser *flags.Parser) error {
+	option := parser.Command.FindOptionByLongName("download-directory")
+	if option == nil {
+		panic("SHOULD NOT HAPPEN: option is nil")
+	}
+	path := option.Default[0]
+	newpath, err := homedir.Expand(path)
+	if err != nil {
+		return fmt.Errorf("Couldn't expand download directory %s: %w", path, err)
+	}
+	option.Default[0] = newpath
+	option.DefaultMask = path
+	return nil
+}
+
+func setDefaultConfigDirectory(parser *flags.Parser) error {
+	option := parser.Command.FindOptionByLongName("config-directory")
+	if option == nil {
+		panic("SHOULD NOT HAPPEN: option is nil")
+	}
+	configpath := configdir.LocalConfig("FA Downloader")
+	option.Default = []string{configpath}
+
+	// replace full path to home directory with ~
+	home, err := homedir.Dir()
+	if err != nil {
+		return fmt.Errorf("Couldn't find home directory: %w", err)
+	}
+	if strings.HasPrefix(configpath, home) {
+		option.DefaultMask = strings.Replace(configpath, home, "~", 1)
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package fa
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var firstTenDigits = regexp.MustCompile(`^\d{10}`)
+var brokenFilename = regexp.MustCompile(`^\d{10}\.$`)
+
+// DownloadResult describes what Download did with a submission's image
+type DownloadResult struct {
+	Filename string
+	Size     int64
+	// Skipped is true if file already existed and its size matched
+	Skipped bool
+	// Resumed is how many bytes were kept from a previous partial download
+	Resumed int64
+	// Embedded is true if metadata was written into the image
+	Embedded bool
+}
+
+// Download saves submission's image into download directory and records it
+// in the database. If it fails, failure is recorded in the database so that
+// it can be retried on the next run.
+func (c *Client) Download(ctx context.Context, sub *Submission) (*DownloadResult, error) {
+	result, err := c.download(ctx, sub)
+	c.options.Progress.DownloadFinished(sub, err)
+	if err != nil {
+		c.recordFailure(sub.PageURL, sub.ImageURL, err)
+		return nil, err
+	}
+	err = c.Store.ClearFailure(sub.PageURL)
+	if err != nil {
+		Log.Warn("Failed to clear failure from database", PageFields(sub.PageURL).With(Fields{"error": err}))
+	}
+	return result, nil
+}
+
+func (c *Client) download(ctx context.Context, sub *Submission) (*DownloadResult, error) {
+	// metadata isn't loaded when retrying failures with known image URL,
+	// and broken filenames are named after the artist it knows
+	meta := c.withStoredMetadata(sub)
+	c.withSource(meta)
+	filename := imageFilename(meta)
+	filepath, err := c.choosePath(meta, filename)
+	if err != nil {
+		return nil, &StageError{Stage: StageDatabase, Err: err}
+	}
+	result := &DownloadResult{Filename: c.storedFilename(filepath)}
+
+	// create download directory if needed
+	err = os.MkdirAll(path.Dir(filepath), 0700)
+	if err != nil {
+		return nil, &StageError{Stage: StageFile, Err: fmt.Errorf("Couldn't create download directory %s: %w", path.Dir(filepath), err)}
+	}
+
+	// get image's size and whether server lets us resume
+	var contentLength int64
+	var acceptRanges bool
+	err = c.options.Retry.Do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, "HEAD", sub.ImageURL.String(), nil)
+		if err != nil {
+			return fmt.Errorf("Failed to create request for URL '%s': %w", sub.ImageURL, err)
+		}
+		c.cdnrl.Take()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("Failed to HEAD on URL '%s': %w", sub.ImageURL, err)
+		}
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
+		if resp.StatusCode != http.StatusOK {
+			return newStatusError(sub.ImageURL.String(), resp)
+		}
+		contentLength = resp.ContentLength
+		acceptRanges = resp.Header.Get("Accept-Ranges") == "bytes"
+		return nil
+	})
+	if err != nil {
+		return nil, &StageError{Stage: StageHead, Err: fmt.Errorf("Failed to get content length of image at '%s': %w", sub.ImageURL, err)}
+	}
+	result.Size = contentLength
+	Log.Debug("Got image size", PageFields(sub.PageURL).With(Fields{"url": sub.ImageURL, "bytes": contentLength, "accept_ranges": acceptRanges}))
+
+	// check if file exists and filesize matches
+	var stat os.FileInfo
+	var lastModified time.Time
+	if stat, err = os.Stat(filepath); err == nil {
+		if contentLength == stat.Size() {
+			// skip, file exists and size matches
+			lastModified = setImageTime(filepath, filename)
+			result.Skipped = true
+			// write metadata next to the image
+			if c.options.Sidecar == SidecarJSON {
+				err = writeSidecar(filepath, meta)
+				if err != nil {
+					return nil, &StageError{Stage: StageSidecar, Err: err}
+				}
+			}
+			// save to database, we don't know what it was like before
+			// metadata was embedded so both checksums are of the file
+			sum, size, err := hashFile(filepath)
+			if err != nil {
+				return nil, &StageError{Stage: StageFile, Err: err}
+			}
+			err = c.Store.SetImage(&Image{
+				PageURL:        sub.PageURL,
+				ImageURL:       sub.ImageURL,
+				LastModified:   lastModified,
+				Filename:       c.storedFilename(filepath),
+				Size:           size,
+				OriginalSHA256: sum,
+				SHA256:         sum,
+			})
+			if err != nil {
+				return nil, &StageError{Stage: StageDatabase, Err: fmt.Errorf("Failed updating database: %w", err)}
+			}
+			return result, nil // nothing else needs to be done
+		}
+	}
+
+	// fetch the image, continuing from what previous attempts left behind
+	var f *fetched
+	err = c.options.Retry.Do(ctx, func() error {
+		var err error
+		f, err = c.fetch(ctx, sub, filepath+".download", contentLength, acceptRanges)
+		return err
+	})
+	if err != nil {
+		// when aborted, don't leave half downloaded file behind
+		if ctx.Err() != nil {
+			os.Remove(filepath + ".download")
+		}
+		return nil, &StageError{Stage: StageGet, Err: err}
+	}
+	result.Resumed = f.resumed
+
+	// get last-modified
+	lastmod := f.header.Get("Last-Modified")
+	if len(lastmod) != 0 {
+		lastModified, err = time.Parse(time.RFC1123, lastmod)
+		if err != nil {
+			return nil, &StageError{Stage: StageGet, Err: fmt.Errorf("Failed to parse lastModified from %s, ignoring lastmodified: %w", lastmod, err)}
+		}
+	}
+
+	// rename temporary file to proper name
+	err = os.Rename(filepath+".download", filepath)
+	if err != nil {
+		return nil, &StageError{Stage: StageRename, Err: fmt.Errorf("Failed to rename %s to %s: %w", result.Filename+".download", result.Filename, err)}
+	}
+
+	image := &Image{
+		PageURL:        sub.PageURL,
+		ImageURL:       sub.ImageURL,
+		LastModified:   lastModified,
+		Filename:       c.storedFilename(filepath),
+		Size:           contentLength,
+		OriginalSHA256: f.sha256,
+		SHA256:         f.sha256,
+	}
+
+	// embed metadata into the image itself, image is fine without it
+	if c.options.EmbedMetadata {
+		err = embedMetadata(filepath, meta)
+		if err != nil && err != errUnsupportedFormat {
+			Log.Warn("Failed to embed metadata, keeping image as downloaded", PageFields(sub.PageURL).With(Fields{"file": result.Filename, "error": err}))
+		}
+		if err == nil {
+			image.SHA256, image.Size, err = hashFile(filepath)
+			if err != nil {
+				return nil, &StageError{Stage: StageFile, Err: err}
+			}
+			result.Embedded = true
+		}
+	}
+
+	// set file's time
+	setImageTime(filepath, filename)
+
+	// write metadata next to the image
+	if c.options.Sidecar == SidecarJSON {
+		err = writeSidecar(filepath, meta)
+		if err != nil {
+			return nil, &StageError{Stage: StageSidecar, Err: err}
+		}
+	}
+
+	// save to database
+	err = c.Store.SetImage(image)
+	if err != nil {
+		return nil, &StageError{Stage: StageDatabase, Err: fmt.Errorf("Failed updating database: %w", err)}
+	}
+	return result, nil
+}
+
+// imageFilename is what FA named submission's image
+func imageFilename(sub *Submission) string {
+	filename := path.Base(sub.ImageURL.Path)
+
+	// if it's "1234567890." (sometimes it happens), then append artist name
+	if m := brokenFilename.FindString(filename); len(m) != 0 && sub.Artist != "" {
+		filename = filename + strings.ToLower(sub.Artist) + ".unnamedimage.jpg"
+	}
+	return filename
+}
+
+// choosePath renders path template for submission and makes sure no other
+// submission's image is, or is about to be, saved there, adding a number
+// to the name if it would be
+func (c *Client) choosePath(sub *Submission, filename string) (string, error) {
+	rendered := c.template.Render(sub, filename)
+	ext := path.Ext(rendered)
+	for n := 1; ; n++ {
+		candidate := rendered
+		if n > 1 {
+			candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(rendered, ext), n, ext)
+		}
+		fullpath := path.Join(c.options.DownloadDirectory, candidate)
+		owner, err := c.Store.FilenameOwner(c.storedFilename(fullpath))
+		if err != nil {
+			return "", fmt.Errorf("Failed to check who owns %s: %w", candidate, err)
+		}
+		if owner != nil && owner.Path != sub.PageURL.Path {
+			continue
+		}
+		if c.paths.reserve(fullpath, sub.PageURL.Path) {
+			return fullpath, nil
+		}
+	}
+}
+
+// fetched is what fetch learned while downloading an image
+type fetched struct {
+	header http.Header
+	// resumed is how many bytes were kept from before
+	resumed int64
+	// sha256 is the hex encoded checksum of the whole file
+	sha256 string
+}
+
+// fetch downloads image into temporary file at downloadpath, appending to it
+// with a Range request if it's already partially there. Checksum is
+// computed while the image streams in.
+func (c *Client) fetch(ctx context.Context, sub *Submission, downloadpath string, contentLength int64, acceptRanges bool) (*fetched, error) {
+	// if previous attempt left a partial file behind, try to continue it
+	var offset int64
+	if acceptRanges && contentLength > 0 {
+		if stat, err := os.Stat(downloadpath); err == nil && stat.Size() < contentLength {
+			offset = stat.Size()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", sub.ImageURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request for URL '%s': %w", sub.ImageURL, err)
+	}
+	if offset > 0 {
+		Log.Debug("Resuming download", PageFields(sub.PageURL).With(Fields{"file": downloadpath, "resumed": offset, "bytes": contentLength}))
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	c.cdnrl.Take()
+	resp, err := http.DefaultClient.Do(req)
+	if resp != nil { // even if err != nil, resp can be not nil as well
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get URL '%s': %w", sub.ImageURL, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, newStatusError(sub.ImageURL.String(), resp)
+	}
+
+	// server ignored our range and is sending the whole file
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		offset = 0
+	}
+
+	// create (or append to) temporary download file
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	out, err := os.OpenFile(downloadpath, flags, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create file '%s': %w", downloadpath, err)
+	}
+	defer out.Close()
+
+	// checksum has to cover the part we already have too
+	h := sha256.New()
+	if offset > 0 {
+		err = hashPrefix(h, downloadpath, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// save the image
+	c.options.Progress.DownloadStarted(sub, path.Base(strings.TrimSuffix(downloadpath, ".download")), contentLength, offset)
+	written, err := io.Copy(io.MultiWriter(out, h, progressWriter{c.options.Progress, sub}), resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download URL '%s' (%v bytes kept for resuming): %w", sub.ImageURL, offset+written, err)
+	}
+
+	if offset+written != contentLength {
+		return nil, fmt.Errorf("Content length of %v != %v written (%v resumed), not marking as done", contentLength, offset+written, offset)
+	}
+	return &fetched{
+		header:  resp.Header,
+		resumed: offset,
+		sha256:  hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// setImageTime sets file's modification time from the unix timestamp FA puts
+// at the start of image filenames. Filename is what FA named the image, file
+// at filepath may be named differently.
+func setImageTime(filepath string, filename string) time.Time {
+	var t time.Time
+	m := firstTenDigits.FindString(filename)
+	if len(m) == 0 {
+		return t
+	}
+	value, err := strconv.ParseInt(m, 10, 64)
+	if err != nil {
+		Log.Warn("Couldn't parse timestamp in filename", Fields{"file": filename, "error": err})
+		return t
+	}
+	t = time.Unix(value, 0)
+	if t.Year() < 2000 {
+		Log.Debug("Not setting file time, year is before 2000", Fields{"file": filename, "timestamp": value})
+		return t
+	}
+	if time.Now().Before(t) {
+		Log.Debug("Not setting file time, it's in the future", Fields{"file": filename, "timestamp": value})
+		return t
+	}
+	err = os.Chtimes(filepath, t, t)
+	if err != nil {
+		Log.Warn("Couldn't change file time", Fields{"file": filepath, "error": err})
+		return t
+	}
+	return t
+}
@@ -0,0 +1,158 @@
+package fa
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDownloadBrokenFilenameFromStoredMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+	client := testClient(t, tempDir(t), server.URL)
+
+	pageURL, _ := url.Parse(URLbase + "/view/38123456/")
+	imageURL, _ := url.Parse(server.URL + "/art/tojo/1598972640/1598972640.")
+	err := client.Store.SetSubmission(&Submission{ID: 38123456, PageURL: pageURL, ImageURL: imageURL, Artist: "Tojo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// retried failures only know page and image
+	result, err := client.Download(context.Background(), &Submission{PageURL: pageURL, ImageURL: imageURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1598972640.tojo.unnamedimage.jpg"; result.Filename != want {
+		t.Errorf("Got filename %q, want %q", result.Filename, want)
+	}
+}
+
+func TestDownloadGoneIsGivenUp(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	client := testClient(t, tempDir(t), server.URL)
+
+	pageURL, _ := url.Parse(URLbase + "/view/38123456/")
+	imageURL, _ := url.Parse(server.URL + "/art/tojo/1598972640/1598972640.tojo_image.jpg")
+	_, err := client.Download(context.Background(), &Submission{PageURL: pageURL, ImageURL: imageURL})
+	if !GivenUp(err) {
+		t.Fatalf("Got error %v, want it given up", err)
+	}
+
+	failures, err := client.Store.Failures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || !failures[0].GivenUp {
+		t.Errorf("Got failures %+v, want one given up", failures)
+	}
+	stats, err := client.Store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failures != 0 || stats.GivenUp != 1 {
+		t.Errorf("Got %d failures waiting for retry and %d given up, want 0 and 1", stats.Failures, stats.GivenUp)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	sum := sha256.Sum256(content)
+	kept := int64(4000)
+	tests := []struct {
+		name string
+		// handler serves content, honoring Range or not
+		handler func(w http.ResponseWriter, r *http.Request)
+		// partial is what previous attempt left behind
+		partial     []byte
+		wantResumed int64
+	}{
+		{
+			name: "range honored",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "image.png", time.Unix(1598972640, 0), bytes.NewReader(content))
+			},
+			partial:     content[:kept],
+			wantResumed: kept,
+		},
+		{
+			name: "range ignored",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				if r.Method != "HEAD" {
+					w.Write(content)
+				}
+			},
+			// not a prefix of content, so it has to be thrown away
+			partial:     bytes.Repeat([]byte("x"), int(kept)),
+			wantResumed: 0,
+		},
+	}
+	for _, test := range tests {
+		var ranges []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				ranges = append(ranges, r.Header.Get("Range"))
+			}
+			test.handler(w, r)
+		}))
+		client := testClient(t, tempDir(t), server.URL)
+
+		pageURL, _ := url.Parse(URLbase + "/view/38123456/")
+		imageURL, _ := url.Parse(server.URL + "/art/tojo/1598972640/1598972640.tojo_night.png")
+		filepath := path.Join(client.options.DownloadDirectory, "1598972640.tojo_night.png")
+		err := os.MkdirAll(client.options.DownloadDirectory, 0700)
+		if err == nil {
+			err = ioutil.WriteFile(filepath+".download", test.partial, 0666)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := client.Download(context.Background(), &Submission{PageURL: pageURL, ImageURL: imageURL})
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if want := "bytes=" + strconv.FormatInt(kept, 10) + "-"; len(ranges) != 1 || ranges[0] != want {
+			t.Errorf("%s: got Range headers %q, want %q", test.name, ranges, want)
+		}
+		if result.Resumed != test.wantResumed {
+			t.Errorf("%s: resumed %d bytes, want %d", test.name, result.Resumed, test.wantResumed)
+		}
+		data, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("%s: got %d bytes that aren't the image", test.name, len(data))
+		}
+		if _, err := os.Stat(filepath + ".download"); !os.IsNotExist(err) {
+			t.Errorf("%s: temporary file is left behind", test.name)
+		}
+		err = client.Store.EachImage(func(image *Image) error {
+			if image.OriginalSHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s: stored checksum %s isn't of the whole image", test.name, image.OriginalSHA256)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
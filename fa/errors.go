@@ -0,0 +1,35 @@
+package fa
+
+import "errors"
+
+// Sentinel errors, matched with errors.Is against what Client methods
+// return
+var (
+	// ErrNotLoggedIn means FA sent a logged out page, session in cookie jar
+	// has expired or was never there
+	ErrNotLoggedIn = errors.New("Not logged in")
+	// ErrRateLimited means FA kept answering 429 Too Many Requests until
+	// retries ran out
+	ErrRateLimited = errors.New("Rate limited")
+	// ErrArtistNotFound means there's no such artist
+	ErrArtistNotFound = errors.New("Artist not found")
+	// ErrPartialFailure means some images couldn't be downloaded, they'll be
+	// retried on the next run
+	ErrPartialFailure = errors.New("Some images failed")
+)
+
+// Is lets errors.Is match page errors against sentinel errors
+func (e *PageError) Is(target error) bool {
+	switch target {
+	case ErrNotLoggedIn:
+		return e.Class == PageLoggedOut
+	case ErrArtistNotFound:
+		return e.Class == PageArtistNotFound
+	}
+	return false
+}
+
+// Is lets errors.Is match status errors against sentinel errors
+func (e *StatusError) Is(target error) bool {
+	return target == ErrRateLimited && e.StatusCode == 429
+}
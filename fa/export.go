@@ -0,0 +1,108 @@
+package fa
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	ExportJSON = "json"
+	ExportCSV  = "csv"
+)
+
+// exported is a single downloaded image together with its metadata
+type exported struct {
+	PageURL      string     `json:"page_url"`
+	ImageURL     string     `json:"image_url"`
+	Filename     string     `json:"filename"`
+	Size         int64      `json:"size"`
+	SHA256       string     `json:"sha256"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	ID           int64      `json:"id,omitempty"`
+	Artist       string     `json:"artist"`
+	Title        string     `json:"title"`
+	Posted       *time.Time `json:"posted,omitempty"`
+	Rating       string     `json:"rating"`
+	Tags         []string   `json:"tags"`
+}
+
+var exportColumns = []string{"page_url", "image_url", "filename", "size", "sha256", "last_modified", "id", "artist", "title", "posted", "rating", "tags"}
+
+// Export writes every downloaded image with its stored metadata into w,
+// either as JSON, one object per line, or as CSV with a header
+func (s *Store) Export(w io.Writer, format string) error {
+	if format != ExportJSON && format != ExportCSV {
+		return fmt.Errorf("Unknown export format %s", format)
+	}
+
+	// metadata is looked up separately, so collect images first
+	images := []*Image{}
+	err := s.EachImage(func(image *Image) error {
+		images = append(images, image)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to read images from database: %w", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	writer := csv.NewWriter(w)
+	if format == ExportCSV {
+		writer.Write(exportColumns)
+	}
+	for _, image := range images {
+		row := &exported{
+			PageURL:  image.PageURL.String(),
+			ImageURL: image.ImageURL.String(),
+			Filename: image.Filename,
+			Size:     image.Size,
+			SHA256:   image.SHA256,
+			Tags:     []string{},
+		}
+		if !image.LastModified.IsZero() {
+			row.LastModified = &image.LastModified
+		}
+		sub, err := s.SubmissionByPage(image.PageURL)
+		if err != nil {
+			return fmt.Errorf("Failed to read metadata of %s from database: %w", image.PageURL, err)
+		}
+		if sub != nil {
+			row.ID = sub.ID
+			row.Artist = sub.Artist
+			row.Title = sub.Title
+			row.Rating = sub.Rating
+			row.Tags = sub.Tags
+			if !sub.PostedAt.IsZero() {
+				row.Posted = &sub.PostedAt
+			}
+		}
+
+		if format == ExportJSON {
+			err = encoder.Encode(row)
+		} else {
+			err = writer.Write([]string{
+				row.PageURL, row.ImageURL, row.Filename, strconv.FormatInt(row.Size, 10), row.SHA256,
+				formatTime(row.LastModified), strconv.FormatInt(row.ID, 10), row.Artist, row.Title,
+				formatTime(row.Posted), row.Rating, strings.Join(row.Tags, " "),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to write export: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,159 @@
+package fa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Stages of getting a submission, recorded with failures
+const (
+	StagePage     = "page"
+	StageGallery  = "gallery"
+	StageFile     = "file"
+	StageHead     = "HEAD"
+	StageGet      = "GET"
+	StageRename   = "rename"
+	StageDatabase = "db"
+	StageSidecar  = "sidecar"
+	StageVerify   = "verify"
+)
+
+// StageError tells at which stage getting a submission failed
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// Failure is a submission that couldn't be downloaded
+type Failure struct {
+	PageURL  *url.URL
+	ImageURL *url.URL
+	Stage    string
+	Error    string
+	Attempts int
+	FailedAt time.Time
+	// GivenUp is set when retrying wouldn't help, like when image is gone
+	GivenUp bool
+}
+
+// GivenUp reports whether err means that retrying is pointless: server
+// answered with a status that isn't temporary (like 404), or FA showed a
+// system message (like for a deleted submission)
+func GivenUp(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return !statusErr.Temporary()
+	}
+	var pageErr *PageError
+	if errors.As(err, &pageErr) {
+		return !pageErr.Fatal()
+	}
+	return false
+}
+
+// recordFailure stores err in the failures table
+func (c *Client) recordFailure(pageURL *url.URL, imageURL *url.URL, err error) {
+	// being interrupted or logged out isn't the submission's fault
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	var pageErr *PageError
+	if errors.As(err, &pageErr) && pageErr.Fatal() {
+		return
+	}
+	stage := "unknown"
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		stage = stageErr.Stage
+	}
+	dbErr := c.Store.RecordFailure(pageURL, imageURL, stage, err, GivenUp(err))
+	if dbErr != nil {
+		Log.Error("Failed to record failure in database", PageFields(pageURL).With(Fields{"error": dbErr}))
+	}
+}
+
+// RecordFailure remembers that submission page failed at stage with given
+// error, counting how many times it did so. Given up failures aren't
+// retried.
+func (s *Store) RecordFailure(pageURL *url.URL, imageURL *url.URL, stage string, failure error, givenUp bool) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	image := ""
+	if imageURL != nil {
+		image = imageURL.String()
+	}
+	err = sqlitex.Exec(db, `INSERT INTO failures (page_url, image_url, stage, error, attempts, failed_at, given_up) VALUES (?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT(page_url) DO UPDATE SET image_url = excluded.image_url, stage = excluded.stage, error = excluded.error, attempts = attempts + 1, failed_at = excluded.failed_at, given_up = excluded.given_up`,
+		nil, pageURL.String(), image, stage, failure.Error(), time.Now().Unix(), givenUp)
+	if err != nil {
+		return fmt.Errorf("Couldn't execute SQL query for recording failure: %w", err)
+	}
+	return nil
+}
+
+// ClearFailure forgets about submission page failing
+func (s *Store) ClearFailure(pageURL *url.URL) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	return sqlitex.Exec(db, "DELETE FROM failures WHERE page_url = ?", nil, pageURL.String())
+}
+
+// Failures returns all recorded failures, given up ones too, oldest first
+func (s *Store) Failures() ([]*Failure, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	failures := []*Failure{}
+	fn := func(stmt *sqlite.Stmt) error {
+		pageURL, err := url.Parse(stmt.ColumnText(0))
+		if err != nil {
+			return err
+		}
+		f := &Failure{
+			PageURL:  pageURL,
+			Stage:    stmt.ColumnText(2),
+			Error:    stmt.ColumnText(3),
+			Attempts: stmt.ColumnInt(4),
+			FailedAt: time.Unix(stmt.ColumnInt64(5), 0),
+			GivenUp:  stmt.ColumnInt(6) != 0,
+		}
+		if image := stmt.ColumnText(1); image != "" {
+			f.ImageURL, err = url.Parse(image)
+			if err != nil {
+				return err
+			}
+		}
+		failures = append(failures, f)
+		return nil
+	}
+	err = sqlitex.Exec(db, "SELECT page_url, image_url, stage, error, attempts, failed_at, given_up FROM failures ORDER BY failed_at", fn)
+	if err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
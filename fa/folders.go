@@ -0,0 +1,108 @@
+package fa
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+var galleryFolderLink = regexp.MustCompile(`^/gallery/([^/]+)/folder/(\d+)/([^/]*)`)
+
+// GalleryFolder is a folder listed in the sidebar of artist's gallery
+type GalleryFolder struct {
+	Folder
+	// path is folder's first page, pages after it are numbered like gallery
+	// pages
+	path string
+}
+
+// GalleryFolders returns folders artist organized their gallery into, in
+// the order the sidebar lists them
+func (c *Client) GalleryFolders(ctx context.Context, artist string) ([]*GalleryFolder, error) {
+	galleryPage := fmt.Sprintf("%s/gallery/%s/", c.options.BaseURL, artist)
+	err := c.Open(ctx, galleryPage)
+	if err != nil {
+		return nil, fmt.Errorf("Got error while getting %s: %w", galleryPage, err)
+	}
+
+	seen := map[int64]bool{}
+	folders := []*GalleryFolder{}
+	for _, link := range c.Browser.Links() {
+		m := galleryFolderLink.FindStringSubmatch(link.URL.Path)
+		// sidebar may link to folders of other artists, like in a journal
+		if m == nil || !strings.EqualFold(m[1], artist) {
+			continue
+		}
+		id, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		name := strings.TrimSpace(link.Text)
+		if name == "" {
+			name = m[3]
+		}
+		folders = append(folders, &GalleryFolder{Folder: Folder{ID: id, Name: name}, path: strings.TrimSuffix(m[0], "/")})
+	}
+	return folders, nil
+}
+
+// FolderPages returns links to submissions found on page number n of
+// gallery folder
+func (c *Client) FolderPages(ctx context.Context, folder *GalleryFolder, n int) ([]*url.URL, error) {
+	return c.submissionLinks(ctx, fmt.Sprintf("%s%s/%d/", c.options.BaseURL, folder.path, n))
+}
+
+// RecordFolder remembers that submission pages are in folder. Pages that
+// aren't submissions are ignored. It returns how many of them weren't known
+// to be in folder before.
+func (s *Store) RecordFolder(pages []*url.URL, folder Folder) (added int, err error) {
+	db, err := s.get()
+	if err != nil {
+		return 0, err
+	}
+	defer s.put(db)
+	defer sqlitex.Save(db)(&err)
+
+	for _, page := range pages {
+		id, ok := SubmissionID(page)
+		if !ok {
+			continue
+		}
+		err = sqlitex.Exec(db, "INSERT OR IGNORE INTO submission_folders (submission_id, folder_id, folder_name) VALUES (?, ?, ?)", nil, id, folder.ID, folder.Name)
+		if err != nil {
+			return added, fmt.Errorf("Couldn't execute SQL query for recording folder: %w", err)
+		}
+		added += db.Changes()
+	}
+	return added, nil
+}
+
+// FoldersOf returns folders submission is known to be in
+func (s *Store) FoldersOf(pageURL *url.URL) ([]Folder, error) {
+	id, ok := SubmissionID(pageURL)
+	if !ok {
+		return []Folder{}, nil
+	}
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	folders := []Folder{}
+	err = sqlitex.Exec(db, "SELECT folder_id, folder_name FROM submission_folders WHERE submission_id = ? ORDER BY rowid", func(stmt *sqlite.Stmt) error {
+		folders = append(folders, Folder{ID: stmt.ColumnInt64(0), Name: stmt.ColumnText(1)})
+		return nil
+	}, id)
+	if err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
@@ -0,0 +1,173 @@
+package fa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how important a log event is
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel turns level name into Level
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level %s", name)
+}
+
+// Log formats
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// Fields are key-value pairs describing a log event, like artist or byte
+// count
+type Fields map[string]interface{}
+
+// Logger writes every event as a single line, so that events from
+// concurrent downloads don't get mixed up
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format string
+}
+
+// NewLogger creates logger that writes events at level and above to out, in
+// format LogText or LogJSON
+func NewLogger(out io.Writer, level Level, format string) *Logger {
+	return &Logger{out: out, level: level, format: format}
+}
+
+// Log is where the downloader logs to. It logs text to standard output
+// until replaced with a configured logger.
+var Log = NewLogger(os.Stdout, LevelInfo, LogText)
+
+// Debug logs what's only interesting when figuring out a problem
+func (l *Logger) Debug(msg string, fields Fields) { l.log(LevelDebug, msg, fields) }
+
+// Info logs progress
+func (l *Logger) Info(msg string, fields Fields) { l.log(LevelInfo, msg, fields) }
+
+// Warn logs something that went wrong but didn't stop anything
+func (l *Logger) Warn(msg string, fields Fields) { l.log(LevelWarn, msg, fields) }
+
+// Error logs something that failed
+func (l *Logger) Error(msg string, fields Fields) { l.log(LevelError, msg, fields) }
+
+func (l *Logger) log(level Level, msg string, fields Fields) {
+	if level < l.level {
+		return
+	}
+	now := time.Now()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	if l.format == LogJSON {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for _, key := range keys {
+			b.WriteString(",")
+			writeJSON(&b, key)
+			b.WriteString(":")
+			writeJSON(&b, fieldValue(fields[key]))
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%s %-5s %s", now.Format("2006-01-02 15:04:05"), strings.ToUpper(level.String()), msg)
+		for _, key := range keys {
+			value := fmt.Sprint(fieldValue(fields[key]))
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = strconv.Quote(value)
+			}
+			fmt.Fprintf(&b, " %s=%s", key, value)
+		}
+		b.WriteString("\n")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+// fieldValue turns values that don't encode well, like errors, into strings
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case *url.URL:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case time.Duration:
+		return v.String()
+	}
+	return value
+}
+
+func writeJSON(b *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(encoded)
+}
+
+// With returns fields together with more, without changing either
+func (f Fields) With(more Fields) Fields {
+	fields := make(Fields, len(f)+len(more))
+	for key, value := range f {
+		fields[key] = value
+	}
+	for key, value := range more {
+		fields[key] = value
+	}
+	return fields
+}
+
+// PageFields describes submission page in log events
+func PageFields(pageURL *url.URL) Fields {
+	fields := Fields{"page": pageURL}
+	if id, ok := SubmissionID(pageURL); ok {
+		fields["submission_id"] = id
+	}
+	return fields
+}
@@ -0,0 +1,126 @@
+package fa
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// login page with the image captcha instead of reCAPTCHA, which can't be
+// solved outside of a real browser
+const loginPage = "/login/?mode=imagecaptcha"
+
+// only logged in users can see this page
+const loggedInPage = "/msg/submissions/"
+
+// Login submits FA's login form. Captcha image is saved into config
+// directory and its path given to solve, which returns what the captcha
+// says. On success the session is saved into the cookie jar and name of the
+// logged in user is returned.
+func (c *Client) Login(ctx context.Context, username, password string, solve func(captchaPath string) (string, error)) (string, error) {
+	err := c.load(ctx, c.options.BaseURL+loginPage)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open login page: %w", err)
+	}
+	form, err := c.Browser.Form("form:has(input[name=pass])")
+	if err != nil {
+		return "", fmt.Errorf("Couldn't find login form: %w", err)
+	}
+
+	captcha := c.Browser.Find("#captcha_img").AttrOr("src", "")
+	if captcha != "" {
+		captchaURL, err := c.Browser.ResolveStringUrl(captcha)
+		if err != nil {
+			return "", fmt.Errorf("Bad captcha URL %s: %w", captcha, err)
+		}
+		captchaPath := path.Join(c.options.ConfigDir, "captcha.jpg")
+		err = c.saveCaptcha(ctx, captchaURL, captchaPath)
+		if err != nil {
+			return "", err
+		}
+		answer, err := solve(captchaPath)
+		os.Remove(captchaPath)
+		if err != nil {
+			return "", err
+		}
+		err = form.Input("captcha", strings.TrimSpace(answer))
+		if err != nil {
+			return "", fmt.Errorf("Couldn't fill in captcha: %w", err)
+		}
+	}
+
+	err = form.Input("name", username)
+	if err == nil {
+		err = form.Input("pass", password)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Couldn't fill in login form: %w", err)
+	}
+	c.transport.use(ctx)
+	err = form.Submit()
+	if err != nil {
+		return "", fmt.Errorf("Failed to submit login form: %w", err)
+	}
+
+	// confirm with a page only logged in users get
+	user, err := c.LoggedInUser(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Login failed, check username, password and captcha: %w", err)
+	}
+	err = c.Jar.Save()
+	if err != nil {
+		return "", fmt.Errorf("Failed to save cookie jar: %w", err)
+	}
+	return user, nil
+}
+
+// saveCaptcha downloads captcha image with the session's cookies, captcha
+// is tied to the session
+func (c *Client) saveCaptcha(ctx context.Context, captchaURL string, captchaPath string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", captchaURL, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to create request for URL '%s': %w", captchaURL, err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	client := &http.Client{Jar: c.Jar, Transport: c.transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to get captcha: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(captchaURL, resp)
+	}
+
+	out, err := os.OpenFile(captchaPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to create file '%s': %w", captchaPath, err)
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to save captcha to '%s': %w", captchaPath, err)
+	}
+	return nil
+}
+
+// LoggedInUser loads a page only logged in users can see and returns whose
+// session the cookie jar holds
+func (c *Client) LoggedInUser(ctx context.Context) (string, error) {
+	err := c.Open(ctx, c.options.BaseURL+loggedInPage)
+	if err != nil {
+		return "", err
+	}
+	return loggedInUser(c.Browser.Dom()), nil
+}
+
+// loggedInUser finds name of the logged in user in page's header
+func loggedInUser(page *goquery.Selection) string {
+	return strings.TrimPrefix(cleanText(page.Find("#my-username").First()), "~")
+}
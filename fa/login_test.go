@@ -0,0 +1,142 @@
+package fa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+// loginServer imitates FA's login: a form with image captcha tied to the
+// session, and a page only logged in users see
+func loginServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+			fmt.Fprint(w, `<html><head><title>Login</title></head><body>
+<form method="post" action="/login/">
+<input type="text" name="name"><input type="password" name="pass">
+<img id="captcha_img" src="/captcha.jpg"><input type="text" name="captcha">
+<button type="submit">Login</button>
+</form></body></html>`)
+			return
+		}
+		session, err := r.Cookie("session")
+		if err != nil || session.Value != "s1" || r.FormValue("name") != "tester" || r.FormValue("pass") != "secret" || r.FormValue("captcha") != "abc123" {
+			fmt.Fprint(w, `<html><head><title>Login</title></head><body>Wrong</body></html>`)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "a", Value: "logged-in", Path: "/", MaxAge: 3600})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/captcha.jpg", func(w http.ResponseWriter, r *http.Request) {
+		if session, err := r.Cookie("session"); err != nil || session.Value != "s1" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("captcha image"))
+	})
+	mux.HandleFunc("/msg/submissions/", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("a"); err != nil {
+			fmt.Fprint(w, `<html><head><title>Log In</title></head><body><a href="/login/">Log In</a></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><head><title>Submissions</title></head><body><a id="my-username" href="/user/tester/">~tester</a></body></html>`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Home</title></head><body></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// testClient makes a client with config in dir that loads pages from
+// baseURL and doesn't retry
+func testClient(t *testing.T, dir string, baseURL string) *Client {
+	retry := DefaultRetryPolicy
+	retry.MaxAttempts = 1
+	client, err := NewClient(context.Background(), Options{
+		ConfigDir:         dir,
+		DownloadDirectory: path.Join(dir, "images"),
+		Retry:             retry,
+		BaseURL:           baseURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestLogin(t *testing.T) {
+	server := loginServer(t)
+	client := testClient(t, tempDir(t), server.URL)
+
+	user, err := client.Login(context.Background(), "tester", "secret", func(captchaPath string) (string, error) {
+		captcha, err := ioutil.ReadFile(captchaPath)
+		if err != nil {
+			return "", err
+		}
+		if string(captcha) != "captcha image" {
+			t.Errorf("Got captcha %q", captcha)
+		}
+		return " abc123\n", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "tester" {
+		t.Errorf("Got user %q, want tester", user)
+	}
+	if _, err := os.Stat(path.Join(client.options.ConfigDir, "captcha.jpg")); !os.IsNotExist(err) {
+		t.Errorf("Captcha wasn't removed after solving")
+	}
+
+	// session is saved, a new client is logged in too
+	jar, err := ioutil.ReadFile(path.Join(client.options.ConfigDir, "cookies.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := tempDir(t)
+	err = ioutil.WriteFile(path.Join(dir, "cookies.json"), jar, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := testClient(t, dir, server.URL)
+	user, err = again.LoggedInUser(context.Background())
+	if err != nil || user != "tester" {
+		t.Errorf("Got user %q, %v from saved session, want tester", user, err)
+	}
+}
+
+func TestLoginWrongCaptcha(t *testing.T) {
+	server := loginServer(t)
+	client := testClient(t, tempDir(t), server.URL)
+
+	_, err := client.Login(context.Background(), "tester", "secret", func(captchaPath string) (string, error) {
+		return "wrong", nil
+	})
+	if err == nil {
+		t.Fatal("Login with wrong captcha succeeded")
+	}
+	var pageErr *PageError
+	if !errors.As(err, &pageErr) || pageErr.Class != PageLoggedOut {
+		t.Errorf("Got %v, want logged out page error", err)
+	}
+}
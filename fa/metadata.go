@@ -0,0 +1,318 @@
+package fa
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"github.com/PuerkitoBio/goquery"
+)
+
+var submissionID = regexp.MustCompile(`/view/(\d+)`)
+var folderLink = regexp.MustCompile(`/gallery/[^/]+/folder/(\d+)/([^/]*)`)
+var userLink = regexp.MustCompile(`/user/([^/]+)`)
+
+// layouts FA uses for posting dates, depending on user's settings. Ordinal
+// suffixes of days, like in "Sep 1st", are stripped before parsing.
+var postedLayouts = []string{
+	"Jan 2, 2006 03:04 PM",
+	"Jan 2, 2006 3:04 PM",
+	"Jan 2, 2006 03:04:05 PM",
+}
+
+var dayOrdinal = regexp.MustCompile(`(\d)(?:st|nd|rd|th),`)
+
+// Folder is a gallery folder submission was put into by its artist
+type Folder struct {
+	ID   int64
+	Name string
+}
+
+// SubmissionID extracts numeric submission ID from a /view/ page URL
+func SubmissionID(pageURL *url.URL) (int64, bool) {
+	m := submissionID.FindStringSubmatch(pageURL.Path)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// ImageArtist finds artist's username in image URL, which looks like
+// /art/<username>/<timestamp>/<filename>
+func ImageArtist(imageURL *url.URL) string {
+	if imageURL == nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(imageURL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "art" {
+		return ""
+	}
+	return strings.ToLower(parts[1])
+}
+
+// NormalizeUsername turns artist's name as shown on FA into how FA URLs
+// have it, lowercase and without underscores
+func NormalizeUsername(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+// ArtistUsername is artist's username that paths and profiles go by. Stored
+// metadata doesn't have it, so it's taken from image URL then, or made from
+// the shown name as the last resort.
+func (sub *Submission) ArtistUsername() string {
+	if sub.Username != "" {
+		return sub.Username
+	}
+	if name := ImageArtist(sub.ImageURL); name != "" {
+		return name
+	}
+	return NormalizeUsername(sub.Artist)
+}
+
+// parseSubmission fills submission's metadata from its /view/ page
+func parseSubmission(page *goquery.Selection, sub *Submission) {
+	sub.ID, _ = SubmissionID(sub.PageURL)
+
+	header := page.Find("#submission_page div.submission-id-sub-container")
+	artist := header.Find("a strong").First()
+	sub.Artist = cleanText(artist)
+	if m := userLink.FindStringSubmatch(artist.Parent().AttrOr("href", "")); m != nil {
+		sub.Username = strings.ToLower(m[1])
+	}
+	sub.Title = cleanText(header.Find("div.submission-title").First())
+	if sub.Title == "" {
+		sub.Title = strings.TrimSuffix(page.Find(`meta[property="og:title"]`).AttrOr("content", ""), " by "+sub.Artist)
+	}
+
+	date := header.Find("span.popup_date").First()
+	for _, value := range []string{date.AttrOr("title", ""), cleanText(date)} {
+		if t, ok := parsePosted(value); ok {
+			sub.PostedAt = t
+			break
+		}
+	}
+
+	page.Find("section.info div").Each(func(_ int, div *goquery.Selection) {
+		switch cleanText(div.Find("strong").First()) {
+		case "Category":
+			sub.Category = cleanText(div.Find(".category-name"))
+			sub.Theme = cleanText(div.Find(".type-name"))
+		case "Species":
+			sub.Species = cleanText(div.Find("span").First())
+		case "Gender":
+			sub.Gender = cleanText(div.Find("span").First())
+		}
+	})
+
+	sub.Rating = cleanText(page.Find("div.rating span.rating-box").First())
+
+	sub.Tags = []string{}
+	page.Find("section.tags-row span.tags a").Each(func(_ int, a *goquery.Selection) {
+		if tag := cleanText(a); tag != "" {
+			sub.Tags = append(sub.Tags, tag)
+		}
+	})
+
+	if html, err := page.Find("div.submission-description").First().Html(); err == nil {
+		sub.Description = strings.TrimSpace(html)
+	}
+
+	sub.Views = countIn(page.Find("div.views span.font-large"))
+	sub.Favorites = countIn(page.Find("div.favorites span.font-large"))
+	sub.Comments = countIn(page.Find("div.comments span.font-large"))
+
+	sub.Folders = []Folder{}
+	page.Find("section.folder-list-container a").Each(func(_ int, a *goquery.Selection) {
+		m := folderLink.FindStringSubmatch(a.AttrOr("href", ""))
+		if m == nil {
+			return
+		}
+		id, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return
+		}
+		name := cleanText(a)
+		if name == "" {
+			name = m[2]
+		}
+		sub.Folders = append(sub.Folders, Folder{ID: id, Name: name})
+	})
+}
+
+func cleanText(sel *goquery.Selection) string {
+	return strings.Join(strings.Fields(sel.Text()), " ")
+}
+
+func countIn(sel *goquery.Selection) int {
+	n, _ := strconv.Atoi(strings.ReplaceAll(cleanText(sel.First()), ",", ""))
+	return n
+}
+
+func parsePosted(value string) (time.Time, bool) {
+	value = dayOrdinal.ReplaceAllString(strings.TrimSpace(value), "$1,")
+	for _, layout := range postedLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// SetSubmission stores submission's metadata, replacing what was stored
+// about it before
+func (s *Store) SetSubmission(sub *Submission) (err error) {
+	if sub.ID == 0 {
+		return fmt.Errorf("Submission %s has no ID", sub.PageURL)
+	}
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+	defer sqlitex.Save(db)(&err)
+
+	stmt, err := db.Prepare(`INSERT OR REPLACE INTO submissions (id, page_url, title, artist, posted_at, category, theme, species, gender, rating, description, views, favorites, comments, updated_at)
+		VALUES ($id, $page_url, $title, $artist, $posted_at, $category, $theme, $species, $gender, $rating, $description, $views, $favorites, $comments, $updated_at)`)
+	if err != nil {
+		return fmt.Errorf("Couldn't prepare SQL query for setting submission: %w", err)
+	}
+	stmt.SetInt64("$id", sub.ID)
+	stmt.SetText("$page_url", sub.PageURL.Path)
+	stmt.SetText("$title", sub.Title)
+	stmt.SetText("$artist", sub.Artist)
+	if posted := sub.Posted(); posted.IsZero() {
+		stmt.SetNull("$posted_at")
+	} else {
+		stmt.SetInt64("$posted_at", posted.Unix())
+	}
+	stmt.SetText("$category", sub.Category)
+	stmt.SetText("$theme", sub.Theme)
+	stmt.SetText("$species", sub.Species)
+	stmt.SetText("$gender", sub.Gender)
+	stmt.SetText("$rating", sub.Rating)
+	stmt.SetText("$description", sub.Description)
+	stmt.SetInt64("$views", int64(sub.Views))
+	stmt.SetInt64("$favorites", int64(sub.Favorites))
+	stmt.SetInt64("$comments", int64(sub.Comments))
+	stmt.SetInt64("$updated_at", time.Now().Unix())
+	_, err = stmt.Step()
+	if err != nil {
+		return fmt.Errorf("Couldn't execute SQL query for setting submission: %w", err)
+	}
+
+	err = sqlitex.Exec(db, "DELETE FROM submission_tags WHERE submission_id = ?", nil, sub.ID)
+	if err != nil {
+		return err
+	}
+	for _, tag := range sub.Tags {
+		err = sqlitex.Exec(db, "INSERT OR IGNORE INTO submission_tags (submission_id, tag) VALUES (?, ?)", nil, sub.ID, strings.ToLower(tag))
+		if err != nil {
+			return err
+		}
+	}
+
+	// pages that don't list folders, like in classic theme, keep folders
+	// found by scanning them
+	if len(sub.Folders) > 0 {
+		err = sqlitex.Exec(db, "DELETE FROM submission_folders WHERE submission_id = ?", nil, sub.ID)
+		if err != nil {
+			return err
+		}
+	}
+	for _, folder := range sub.Folders {
+		err = sqlitex.Exec(db, "INSERT OR IGNORE INTO submission_folders (submission_id, folder_id, folder_name) VALUES (?, ?, ?)", nil, sub.ID, folder.ID, folder.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostedAt returns when submission at pageURL was posted, as stored with its
+// metadata. It's zero if that isn't stored.
+func (s *Store) PostedAt(pageURL *url.URL) (time.Time, error) {
+	id, ok := SubmissionID(pageURL)
+	if !ok {
+		return time.Time{}, nil
+	}
+	db, err := s.get()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer s.put(db)
+
+	var posted time.Time
+	err = sqlitex.Exec(db, "SELECT posted_at FROM submissions WHERE id = ? AND posted_at IS NOT NULL", func(stmt *sqlite.Stmt) error {
+		posted = time.Unix(stmt.ColumnInt64(0), 0)
+		return nil
+	}, id)
+	return posted, err
+}
+
+// SubmissionByPage loads stored metadata of submission at pageURL. It
+// returns nil if nothing is stored.
+func (s *Store) SubmissionByPage(pageURL *url.URL) (*Submission, error) {
+	id, ok := SubmissionID(pageURL)
+	if !ok {
+		return nil, nil
+	}
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	var sub *Submission
+	fn := func(stmt *sqlite.Stmt) error {
+		sub = &Submission{
+			ID:          id,
+			PageURL:     pageURL,
+			Title:       stmt.ColumnText(0),
+			Artist:      stmt.ColumnText(1),
+			Category:    stmt.ColumnText(3),
+			Theme:       stmt.ColumnText(4),
+			Species:     stmt.ColumnText(5),
+			Gender:      stmt.ColumnText(6),
+			Rating:      stmt.ColumnText(7),
+			Description: stmt.ColumnText(8),
+			Views:       stmt.ColumnInt(9),
+			Favorites:   stmt.ColumnInt(10),
+			Comments:    stmt.ColumnInt(11),
+			Tags:        []string{},
+			Folders:     []Folder{},
+		}
+		if stmt.ColumnType(2) != sqlite.SQLITE_NULL {
+			sub.PostedAt = time.Unix(stmt.ColumnInt64(2), 0)
+		}
+		return nil
+	}
+	err = sqlitex.Exec(db, "SELECT title, artist, posted_at, category, theme, species, gender, rating, description, views, favorites, comments FROM submissions WHERE id = ?", fn, id)
+	if err != nil || sub == nil {
+		return nil, err
+	}
+
+	err = sqlitex.Exec(db, "SELECT tag FROM submission_tags WHERE submission_id = ? ORDER BY rowid", func(stmt *sqlite.Stmt) error {
+		sub.Tags = append(sub.Tags, stmt.ColumnText(0))
+		return nil
+	}, id)
+	if err != nil {
+		return nil, err
+	}
+	err = sqlitex.Exec(db, "SELECT folder_id, folder_name FROM submission_folders WHERE submission_id = ? ORDER BY rowid", func(stmt *sqlite.Stmt) error {
+		sub.Folders = append(sub.Folders, Folder{ID: stmt.ColumnInt64(0), Name: stmt.ColumnText(1)})
+		return nil
+	}, id)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
@@ -0,0 +1,74 @@
+package fa
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParsePosted(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"Sep 1st, 2020 03:04 PM", time.Date(2020, 9, 1, 15, 4, 0, 0, time.UTC)},
+		{"Sep 2nd, 2020 03:04 PM", time.Date(2020, 9, 2, 15, 4, 0, 0, time.UTC)},
+		{"Sep 23rd, 2020 11:59 AM", time.Date(2020, 9, 23, 11, 59, 0, 0, time.UTC)},
+		{"Sep 4th, 2020 12:00 AM", time.Date(2020, 9, 4, 0, 0, 0, 0, time.UTC)},
+		{"Sep 11th, 2020 03:04 PM", time.Date(2020, 9, 11, 15, 4, 0, 0, time.UTC)},
+		{"Sep 1, 2020 03:04 PM", time.Date(2020, 9, 1, 15, 4, 0, 0, time.UTC)},
+		{"Sep 1, 2020 3:04 PM", time.Date(2020, 9, 1, 15, 4, 0, 0, time.UTC)},
+		{"Sep 1, 2020 03:04:05 PM", time.Date(2020, 9, 1, 15, 4, 5, 0, time.UTC)},
+		{"  Sep 1st, 2020 03:04 PM ", time.Date(2020, 9, 1, 15, 4, 0, 0, time.UTC)},
+		{"4 years ago", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, test := range tests {
+		got, ok := parsePosted(test.value)
+		if ok != !test.want.IsZero() || !got.Equal(test.want) {
+			t.Errorf("parsePosted(%q) = %v, %v, want %v", test.value, got, ok, test.want)
+		}
+	}
+}
+
+func TestParseSubmission(t *testing.T) {
+	f, err := os.Open("testdata/view.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageURL, _ := url.Parse("https://www.furaffinity.net/view/38123456/")
+	sub := &Submission{PageURL: pageURL}
+	parseSubmission(doc.Selection, sub)
+
+	want := &Submission{
+		ID:          38123456,
+		PageURL:     pageURL,
+		Artist:      "Tojo-The-Thief",
+		Username:    "tojo-the-thief",
+		Title:       "Night Market",
+		PostedAt:    time.Date(2020, 9, 1, 15, 4, 0, 0, time.UTC),
+		Category:    "Artwork (Digital)",
+		Theme:       "Scenery",
+		Species:     "Red Fox",
+		Gender:      "Any",
+		Rating:      "General",
+		Tags:        []string{"fox", "night"},
+		Description: "A market <b>at night</b>.",
+		Folders:     []Folder{{ID: 123, Name: "Scenery"}, {ID: 456, Name: "Old & Stuff"}},
+		Views:       1234,
+		Favorites:   56,
+		Comments:    7,
+	}
+	if !reflect.DeepEqual(sub, want) {
+		t.Errorf("parseSubmission got\n%+v\nwant\n%+v", sub, want)
+	}
+}
@@ -0,0 +1,253 @@
+package fa
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// migration is a single step of bringing database schema up to date. Step
+// number i (counting from 1) is applied when PRAGMA user_version is below i.
+type migration struct {
+	description string
+	migrate     func(db *sqlite.Conn) error
+}
+
+// migrations must only ever be appended to, never reordered or edited
+var migrations = []migration{
+	{
+		// databases from before migrations existed already have these, hence
+		// IF NOT EXISTS
+		description: "create image_urls table",
+		migrate: execAll(
+			"CREATE TABLE IF NOT EXISTS image_urls (page_url TEXT PRIMARY KEY UNIQUE, image_url TEXT, last_modified TEXT, filename TEXT)",
+			"CREATE INDEX IF NOT EXISTS page_urls ON image_urls(page_url)",
+		),
+	},
+	{
+		description: "create failures table",
+		migrate: execAll(
+			"CREATE TABLE IF NOT EXISTS failures (page_url TEXT PRIMARY KEY, image_url TEXT, stage TEXT, error TEXT, attempts INTEGER, failed_at INTEGER)",
+		),
+	},
+	{
+		description: "store image_urls.last_modified as unix seconds",
+		migrate:     migrateLastModifiedToUnix,
+	},
+	{
+		description: "create submissions, submission_tags and submission_folders tables",
+		migrate: execAll(
+			"CREATE TABLE submissions (id INTEGER PRIMARY KEY, page_url TEXT, title TEXT, artist TEXT, posted_at INTEGER, category TEXT, theme TEXT, species TEXT, gender TEXT, rating TEXT, description TEXT, views INTEGER, favorites INTEGER, comments INTEGER, updated_at INTEGER)",
+			"CREATE INDEX submissions_artist ON submissions(artist)",
+			"CREATE INDEX submissions_rating ON submissions(rating)",
+			"CREATE TABLE submission_tags (submission_id INTEGER, tag TEXT, PRIMARY KEY (submission_id, tag))",
+			"CREATE INDEX submission_tags_tag ON submission_tags(tag)",
+			"CREATE TABLE submission_folders (submission_id INTEGER, folder_id INTEGER, folder_name TEXT, PRIMARY KEY (submission_id, folder_id))",
+		),
+	},
+	{
+		description: "add checksums to image_urls",
+		migrate: execAll(
+			"ALTER TABLE image_urls ADD COLUMN original_sha256 TEXT",
+			"ALTER TABLE image_urls ADD COLUMN sha256 TEXT",
+		),
+	},
+	{
+		description: "add file size to image_urls",
+		migrate: execAll(
+			"ALTER TABLE image_urls ADD COLUMN size INTEGER",
+		),
+	},
+	{
+		description: "index image_urls by filename",
+		migrate: execAll(
+			"CREATE INDEX image_urls_filename ON image_urls(filename)",
+		),
+	},
+	{
+		description: "create reorganize_journal table",
+		migrate: execAll(
+			"CREATE TABLE reorganize_journal (page_url TEXT PRIMARY KEY, old_filename TEXT, new_filename TEXT)",
+		),
+	},
+	{
+		description: "create sources table",
+		migrate: execAll(
+			"CREATE TABLE sources (submission_id INTEGER, artist_scanned TEXT, page_type TEXT, first_seen INTEGER, last_seen INTEGER, PRIMARY KEY (submission_id, artist_scanned, page_type))",
+			"CREATE INDEX sources_artist ON sources(artist_scanned, page_type)",
+		),
+	},
+	{
+		description: "create artists table",
+		migrate: execAll(
+			"CREATE TABLE artists (artist TEXT, page_type TEXT, started_at INTEGER, last_completed INTEGER, highest_id INTEGER, completed INTEGER, PRIMARY KEY (artist, page_type))",
+		),
+	},
+	{
+		description: "add given_up to failures",
+		migrate: execAll(
+			"ALTER TABLE failures ADD COLUMN given_up INTEGER NOT NULL DEFAULT 0",
+		),
+	},
+}
+
+func execAll(statements ...string) func(db *sqlite.Conn) error {
+	return func(db *sqlite.Conn) error {
+		for _, statement := range statements {
+			err := sqlitex.ExecTransient(db, statement, nil)
+			if err != nil {
+				return fmt.Errorf("Failed to execute statement %s: %w", statement, err)
+			}
+		}
+		return nil
+	}
+}
+
+// migrateLastModifiedToUnix rewrites last_modified from time.Time.String()
+// text into unix seconds, or NULL when it wasn't known
+func migrateLastModifiedToUnix(db *sqlite.Conn) error {
+	err := execAll(
+		"CREATE TABLE image_urls_new (page_url TEXT PRIMARY KEY UNIQUE, image_url TEXT, last_modified INTEGER, filename TEXT)",
+	)(db)
+	if err != nil {
+		return err
+	}
+
+	insert, err := db.Prepare("INSERT INTO image_urls_new (page_url, image_url, last_modified, filename) VALUES ($page_url, $image_url, $last_modified, $filename)")
+	if err != nil {
+		return err
+	}
+	fn := func(stmt *sqlite.Stmt) error {
+		insert.Reset()
+		insert.SetText("$page_url", stmt.ColumnText(0))
+		insert.SetText("$image_url", stmt.ColumnText(1))
+		if t, ok := parseGoTime(stmt.ColumnText(2)); ok {
+			insert.SetInt64("$last_modified", t.Unix())
+		} else {
+			insert.SetNull("$last_modified")
+		}
+		insert.SetText("$filename", stmt.ColumnText(3))
+		_, err := insert.Step()
+		return err
+	}
+	err = sqlitex.Exec(db, "SELECT page_url, image_url, last_modified, filename FROM image_urls", fn)
+	if err != nil {
+		return err
+	}
+
+	return execAll(
+		"DROP TABLE image_urls",
+		"ALTER TABLE image_urls_new RENAME TO image_urls",
+		"CREATE INDEX IF NOT EXISTS page_urls ON image_urls(page_url)",
+		"CREATE INDEX IF NOT EXISTS last_modified ON image_urls(last_modified)",
+	)(db)
+}
+
+// parseGoTime parses output of time.Time.String(), ignoring monotonic clock
+// reading. Zero time is reported as not ok.
+func parseGoTime(value string) (time.Time, bool) {
+	if i := strings.Index(value, " m="); i != -1 {
+		value = value[:i]
+	}
+	t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", value)
+	if err != nil || t.IsZero() {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func schemaVersion(db *sqlite.Conn) (int, error) {
+	var version int
+	fn := func(stmt *sqlite.Stmt) error {
+		version = stmt.ColumnInt(0)
+		return nil
+	}
+	err := sqlitex.ExecTransient(db, "PRAGMA user_version", fn)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations describes migrations that Migrate would apply
+func (s *Store) PendingMigrations() ([]string, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	return pendingMigrations(version), nil
+}
+
+// pendingMigrations describes migrations after schema version
+func pendingMigrations(version int) []string {
+	pending := []string{}
+	for i := version; i < len(migrations); i++ {
+		pending = append(pending, fmt.Sprintf("#%d: %s", i+1, migrations[i].description))
+	}
+	return pending
+}
+
+// Migrate brings database schema up to date. Every step runs in its own
+// transaction together with the schema version bump, so an interrupted
+// migration is picked up where it stopped.
+func (s *Store) Migrate() error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("Database schema version %d is newer than supported %d, please upgrade", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		Log.Info("Migrating database", Fields{"version": i + 1, "migration": migrations[i].description})
+		err = applyMigration(db, i+1, migrations[i])
+		if err != nil {
+			return fmt.Errorf("Failed to apply migration #%d (%s): %w", i+1, migrations[i].description, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sqlite.Conn, version int, m migration) (err error) {
+	defer sqlitex.Save(db)(&err)
+
+	err = m.migrate(db)
+	if err != nil {
+		return err
+	}
+	return sqlitex.ExecTransient(db, fmt.Sprintf("PRAGMA user_version = %d", version), nil)
+}
+
+// PendingMigrations opens the database in configDir read-only just to
+// describe what NewClient would migrate, without changing anything. A
+// database that doesn't exist yet would get every migration.
+func PendingMigrations(configDir string) ([]string, error) {
+	filepath := path.Join(configDir, DatabaseFilename)
+	if _, err := os.Stat(filepath); os.IsNotExist(err) {
+		return pendingMigrations(0), nil
+	}
+	s, err := openStoreReadOnly(context.Background(), filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.PendingMigrations()
+}
@@ -0,0 +1,92 @@
+package fa
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestMigrateLastModifiedToUnix(t *testing.T) {
+	store, err := OpenStore(context.Background(), path.Join(tempDir(t), DatabaseFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	if !strings.Contains(now.String(), " m=") {
+		t.Fatalf("%s has no monotonic clock reading", now)
+	}
+	header, err := time.Parse(time.RFC1123, "Tue, 01 Sep 2020 15:04:00 GMT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// what databases from before migrations have
+	db, err := store.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = execAll(
+		"CREATE TABLE image_urls (page_url TEXT PRIMARY KEY UNIQUE, image_url TEXT, last_modified TEXT, filename TEXT)",
+		"CREATE INDEX page_urls ON image_urls(page_url)",
+	)(db)
+	for _, row := range [][]string{
+		{"/view/1/", now.String()},
+		{"/view/2/", time.Time{}.String()},
+		{"/view/3/", header.String()},
+	} {
+		if err == nil {
+			err = sqlitex.Exec(db, "INSERT INTO image_urls (page_url, image_url, last_modified, filename) VALUES (?, ?, ?, ?)", nil,
+				row[0], "https://d.furaffinity.net/art/tojo/1598972640/1598972640.tojo_night.png", row[1], "1598972640.tojo_night.png")
+		}
+	}
+	store.put(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = store.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.put(db)
+	got := map[string]interface{}{}
+	err = sqlitex.Exec(db, "SELECT page_url, last_modified FROM image_urls", func(stmt *sqlite.Stmt) error {
+		if stmt.ColumnType(1) == sqlite.SQLITE_NULL {
+			got[stmt.ColumnText(0)] = nil
+		} else {
+			got[stmt.ColumnText(0)] = stmt.ColumnInt64(1)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"/view/1/": now.Unix(),
+		"/view/2/": nil,
+		"/view/3/": header.Unix(),
+	}
+	for page, value := range want {
+		if got[page] != value {
+			t.Errorf("%s: got last_modified %v, want %v", page, got[page], value)
+		}
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("Got schema version %d, want %d", version, len(migrations))
+	}
+}
@@ -0,0 +1,95 @@
+package fa
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// PageClass is what kind of page FA answered with
+type PageClass string
+
+// Page classes
+const (
+	PageLoggedIn        PageClass = "logged in"
+	PageLoggedOut       PageClass = "logged out"
+	PageSystemMessage   PageClass = "system message"
+	PageArtistNotFound  PageClass = "artist not found"
+	PageAccountDisabled PageClass = "account disabled"
+	PageMaintenance     PageClass = "maintenance"
+)
+
+// PageError is returned when FA answers with 200 but the page isn't the one
+// asked for, like when session has expired
+type PageError struct {
+	URL   string
+	Class PageClass
+	// Message is what FA said, if it said anything
+	Message string
+}
+
+func (e *PageError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("Got %s page for %s: %s", e.Class, e.URL, e.Message)
+	}
+	return fmt.Sprintf("Got %s page for %s", e.Class, e.URL)
+}
+
+// Fatal reports whether no other page is going to load either, so there's
+// no point in going on
+func (e *PageError) Fatal() bool {
+	return e.Class == PageLoggedOut || e.Class == PageMaintenance
+}
+
+// classifyPage tells what kind of page FA sent. Every page of a logged in
+// user has username in its header, and system messages look the same no
+// matter what they're about, so it's told apart by what they say.
+func classifyPage(page *goquery.Selection) (PageClass, string) {
+	// submission and artist names can say maintenance too, so only the
+	// bare page without the logged-in header is FA's maintenance page
+	user := loggedInUser(page)
+	title := strings.ToLower(page.Find("title").First().Text())
+	if user == "" && strings.Contains(title, "maintenance") {
+		return PageMaintenance, cleanText(page.Find("body"))
+	}
+
+	if message, ok := systemMessage(page); ok {
+		lower := strings.ToLower(message)
+		switch {
+		case strings.Contains(lower, "cannot be found") || strings.Contains(lower, "could not be found"):
+			return PageArtistNotFound, message
+		case strings.Contains(lower, "disabled") || strings.Contains(lower, "suspended") || strings.Contains(lower, "banned"):
+			return PageAccountDisabled, message
+		case strings.Contains(lower, "maintenance"):
+			return PageMaintenance, message
+		}
+		return PageSystemMessage, message
+	}
+
+	if user != "" {
+		return PageLoggedIn, ""
+	}
+	return PageLoggedOut, ""
+}
+
+// systemMessage finds text of "System Message" box, in both the modern and
+// the classic theme
+func systemMessage(page *goquery.Selection) (string, bool) {
+	var message string
+	found := false
+	page.Find("section.notice-message h2, table.maintable td.cat").EachWithBreak(func(_ int, heading *goquery.Selection) bool {
+		text := strings.ToLower(cleanText(heading))
+		if !strings.HasPrefix(text, "system message") && !strings.HasPrefix(text, "system error") {
+			return true
+		}
+		found = true
+		if box := heading.Closest("section.notice-message"); box.Length() > 0 {
+			message = cleanText(box.Find("div.section-body, div.redirect-message").First())
+		} else {
+			message = cleanText(heading.Closest("table.maintable").Find("td.alt1").First())
+		}
+		return false
+	})
+	return message, found
+}
@@ -0,0 +1,66 @@
+package fa
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestClassifyPage(t *testing.T) {
+	tests := []struct {
+		file    string
+		class   PageClass
+		message string
+	}{
+		{"modern_logged_in.html", PageLoggedIn, ""},
+		{"modern_logged_out.html", PageLoggedOut, ""},
+		{"modern_system_message.html", PageSystemMessage, "content filter settings"},
+		{"modern_artist_not_found.html", PageArtistNotFound, `The username "nosuchartist" could not be found.`},
+		{"modern_disabled.html", PageAccountDisabled, "voluntarily disabled access"},
+		{"modern_maintenance.html", PageMaintenance, "We will be back shortly."},
+		{"modern_maintenance_title.html", PageLoggedIn, ""},
+		{"classic_logged_in.html", PageLoggedIn, ""},
+		{"classic_logged_out.html", PageLoggedOut, ""},
+		{"classic_system_message.html", PageSystemMessage, "content filter settings"},
+		{"classic_artist_not_found.html", PageArtistNotFound, `The username "nosuchartist" could not be found.`},
+		{"classic_disabled.html", PageAccountDisabled, "voluntarily disabled access"},
+		{"classic_maintenance.html", PageMaintenance, "undergoing maintenance"},
+	}
+	for _, test := range tests {
+		f, err := os.Open("testdata/pages/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := goquery.NewDocumentFromReader(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		class, message := classifyPage(doc.Selection)
+		if class != test.class {
+			t.Errorf("%s: got %s page, want %s", test.file, class, test.class)
+		}
+		if test.message == "" && message != "" || !strings.Contains(message, test.message) {
+			t.Errorf("%s: got message %q, want it to contain %q", test.file, message, test.message)
+		}
+	}
+}
+
+func TestLoggedInUser(t *testing.T) {
+	for _, file := range []string{"modern_logged_in.html", "classic_logged_in.html"} {
+		f, err := os.Open("testdata/pages/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := goquery.NewDocumentFromReader(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user := loggedInUser(doc.Selection); user != "someone" {
+			t.Errorf("%s: got user %q, want someone", file, user)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package fa
+
+// Progress is told how downloads are going, so that they can be shown.
+// Methods are called from download goroutines, for many submissions at
+// once.
+type Progress interface {
+	// DownloadStarted is called when image starts streaming in, again on
+	// every retry. Resumed bytes were kept from before.
+	DownloadStarted(sub *Submission, filename string, size, resumed int64)
+	// Downloaded is called as image data arrives
+	Downloaded(sub *Submission, n int64)
+	// DownloadFinished is called once Download is done with submission,
+	// whether it worked or not
+	DownloadFinished(sub *Submission, err error)
+}
+
+type noProgress struct{}
+
+func (noProgress) DownloadStarted(*Submission, string, int64, int64) {}
+func (noProgress) Downloaded(*Submission, int64)                     {}
+func (noProgress) DownloadFinished(*Submission, error)               {}
+
+// progressWriter tells progress about data written through it
+type progressWriter struct {
+	progress Progress
+	sub      *Submission
+}
+
+func (w progressWriter) Write(p []byte) (int, error) {
+	w.progress.Downloaded(w.sub, int64(len(p)))
+	return len(p), nil
+}
@@ -0,0 +1,259 @@
+package fa
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Move is a downloaded image that's moved to where path template says it
+// should be. From and To are relative to download directory.
+type Move struct {
+	PageURL *url.URL
+	From    string
+	To      string
+}
+
+// Unplaced is a downloaded image that path template can't place, because
+// its metadata was never stored. Missing are placeholders it has nothing
+// for.
+type Unplaced struct {
+	PageURL  *url.URL
+	Filename string
+	Missing  []string
+}
+
+// PlanReorganize works out where every downloaded image goes with the
+// client's path template, from metadata stored in database. Paths that are
+// taken, by another image or by a file database doesn't know about, are
+// never moved onto. Images saved outside of download directory stay where
+// they are. Images downloaded before metadata was stored only know their
+// artist, from image URL, and stay where they are when template needs
+// more than that.
+func (c *Client) PlanReorganize() ([]*Move, []*Unplaced, error) {
+	images := []*Image{}
+	err := c.Store.EachImage(func(image *Image) error {
+		images = append(images, image)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get images from database: %w", err)
+	}
+
+	// paths images are at now, and will be at once moved
+	taken := map[string]bool{}
+	for _, image := range images {
+		taken[image.Filename] = true
+	}
+
+	moves := []*Move{}
+	unplaced := []*Unplaced{}
+	for _, image := range images {
+		if path.IsAbs(image.Filename) {
+			Log.Debug("Not moving image outside of download directory", PageFields(image.PageURL).With(Fields{"file": image.Filename}))
+			continue
+		}
+		sub, err := c.Store.SubmissionByPage(image.PageURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to get metadata of %s: %w", image.PageURL, err)
+		}
+		stored := sub != nil
+		if !stored {
+			sub = &Submission{PageURL: image.PageURL, Username: ImageArtist(image.ImageURL)}
+		}
+		sub.ImageURL = image.ImageURL
+		c.withSource(sub)
+
+		filename := imageFilename(sub)
+		if !stored {
+			// empty placeholders would be dropped, making a layout that
+			// has to be reorganized again once metadata is known
+			if missing := c.template.Missing(sub, filename); len(missing) > 0 {
+				unplaced = append(unplaced, &Unplaced{PageURL: image.PageURL, Filename: image.Filename, Missing: missing})
+				continue
+			}
+		}
+		rendered := c.template.Render(sub, filename)
+		ext := path.Ext(rendered)
+		for n := 1; ; n++ {
+			candidate := rendered
+			if n > 1 {
+				candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(rendered, ext), n, ext)
+			}
+			if candidate == image.Filename {
+				break
+			}
+			if taken[candidate] {
+				continue
+			}
+			if _, err := os.Stat(c.imagePath(candidate)); err == nil {
+				continue
+			}
+			taken[candidate] = true
+			moves = append(moves, &Move{PageURL: image.PageURL, From: image.Filename, To: candidate})
+			break
+		}
+	}
+	return moves, unplaced, nil
+}
+
+// Reorganize journals moves in database and then makes them. If it's
+// interrupted, moves left in the journal are finished by ResumeReorganize.
+// It returns how many images were moved.
+func (c *Client) Reorganize(ctx context.Context, moves []*Move) (int, error) {
+	err := c.Store.JournalMoves(moves)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to journal moves: %w", err)
+	}
+	return c.ResumeReorganize(ctx)
+}
+
+// ResumeReorganize makes moves left in the journal. Moves that were made
+// but not recorded in database before being interrupted are recognized by
+// file already being at its new path.
+func (c *Client) ResumeReorganize(ctx context.Context) (int, error) {
+	moves, err := c.Store.JournaledMoves()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get journaled moves: %w", err)
+	}
+	moved := 0
+	for _, move := range moves {
+		if ctx.Err() != nil {
+			return moved, ctx.Err()
+		}
+		fields := PageFields(move.PageURL).With(Fields{"from": move.From, "to": move.To})
+		err = c.move(move)
+		if err != nil {
+			Log.Error("Failed to move image", fields.With(Fields{"error": err}))
+			err = c.Store.DropMove(move)
+			if err != nil {
+				return moved, fmt.Errorf("Failed to drop move of %s from journal: %w", move.From, err)
+			}
+			continue
+		}
+		err = c.Store.FinishMove(move)
+		if err != nil {
+			return moved, fmt.Errorf("Failed to record move of %s in database: %w", move.From, err)
+		}
+		Log.Info("Moved image", fields)
+		moved++
+	}
+	return moved, nil
+}
+
+// move renames image and its sidecar, if it has one
+func (c *Client) move(move *Move) error {
+	from := c.imagePath(move.From)
+	to := c.imagePath(move.To)
+	_, fromErr := os.Stat(from)
+	_, toErr := os.Stat(to)
+	switch {
+	case fromErr == nil && toErr == nil:
+		return fmt.Errorf("Both %s and %s exist", move.From, move.To)
+	case fromErr != nil && toErr == nil:
+		// moved already, before being interrupted
+	case fromErr != nil:
+		Log.Warn("Image to move is missing, only updating database", PageFields(move.PageURL).With(Fields{"file": move.From}))
+		return nil
+	default:
+		err := os.MkdirAll(path.Dir(to), 0700)
+		if err != nil {
+			return fmt.Errorf("Couldn't create directory %s: %w", path.Dir(to), err)
+		}
+		err = os.Rename(from, to)
+		if err != nil {
+			return fmt.Errorf("Failed to rename %s to %s: %w", move.From, move.To, err)
+		}
+	}
+	if _, err := os.Stat(from + ".json"); err == nil {
+		err = os.Rename(from+".json", to+".json")
+		if err != nil {
+			return fmt.Errorf("Failed to rename %s to %s: %w", move.From+".json", move.To+".json", err)
+		}
+	}
+	c.removeEmptyDirs(path.Dir(from))
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents inside download directory,
+// as long as they're empty
+func (c *Client) removeEmptyDirs(dir string) {
+	root := path.Clean(c.root)
+	for strings.HasPrefix(dir, root+"/") {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// JournalMoves records moves before they're made, all of them or none
+func (s *Store) JournalMoves(moves []*Move) (err error) {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+	defer sqlitex.Save(db)(&err)
+
+	for _, move := range moves {
+		err = sqlitex.Exec(db, "INSERT OR REPLACE INTO reorganize_journal (page_url, old_filename, new_filename) VALUES (?, ?, ?)", nil, move.PageURL.Path, move.From, move.To)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JournaledMoves returns moves that were journaled but not made yet
+func (s *Store) JournaledMoves() ([]*Move, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	moves := []*Move{}
+	err = sqlitex.Exec(db, "SELECT page_url, old_filename, new_filename FROM reorganize_journal ORDER BY page_url", func(stmt *sqlite.Stmt) error {
+		pageURL, err := url.Parse(URLbase + stmt.ColumnText(0))
+		if err != nil {
+			return err
+		}
+		moves = append(moves, &Move{PageURL: pageURL, From: stmt.ColumnText(1), To: stmt.ColumnText(2)})
+		return nil
+	})
+	return moves, err
+}
+
+// FinishMove records image's new filename and drops its move from journal
+func (s *Store) FinishMove(move *Move) (err error) {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+	defer sqlitex.Save(db)(&err)
+
+	err = sqlitex.Exec(db, "UPDATE image_urls SET filename = ? WHERE page_url = ?", nil, move.To, move.PageURL.Path)
+	if err != nil {
+		return err
+	}
+	return sqlitex.Exec(db, "DELETE FROM reorganize_journal WHERE page_url = ?", nil, move.PageURL.Path)
+}
+
+// DropMove forgets a move that couldn't be made
+func (s *Store) DropMove(move *Move) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	return sqlitex.Exec(db, "DELETE FROM reorganize_journal WHERE page_url = ?", nil, move.PageURL.Path)
+}
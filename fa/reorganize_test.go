@@ -0,0 +1,49 @@
+package fa
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPlanReorganizeWithoutMetadata(t *testing.T) {
+	client := testClient(t, tempDir(t), URLbase)
+
+	// downloaded before metadata was stored, only image URL is known
+	pageURL, _ := url.Parse(URLbase + "/view/38123456/")
+	imageURL, _ := url.Parse("https://d.furaffinity.net/art/tojo/1598972640/1598972640.tojo_night.png")
+	err := client.Store.SetImage(&Image{PageURL: pageURL, ImageURL: imageURL, Filename: "1598972640.tojo_night.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.template, err = ParsePathTemplate("{artist}/{id}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, unplaced, err := client.PlanReorganize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unplaced) != 0 {
+		t.Errorf("Got unplaced %+v, want none", unplaced)
+	}
+	if len(moves) != 1 || moves[0].To != "tojo/38123456.png" {
+		t.Errorf("Got moves %+v, want image moved to tojo/38123456.png", moves)
+	}
+
+	client.template, err = ParsePathTemplate("{artist}/{pagetype}/{id}_{title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves, unplaced, err = client.PlanReorganize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 0 {
+		t.Errorf("Got moves %+v, want none", moves)
+	}
+	if len(unplaced) != 1 || !reflect.DeepEqual(unplaced[0].Missing, []string{"pagetype", "title"}) {
+		t.Errorf("Got unplaced %+v, want image missing pagetype and title", unplaced)
+	}
+}
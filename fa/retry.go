@@ -0,0 +1,125 @@
+package fa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how page loads and image requests are retried
+type RetryPolicy struct {
+	// MaxAttempts is how many times a request is tried before giving up
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled on every next one
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used when Options.Retry is left empty
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    2 * time.Minute,
+}
+
+// StatusError is returned when server replies with an unexpected HTTP status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter is how long server asked us to wait, if it did
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Got status %d %s for %s", e.StatusCode, http.StatusText(e.StatusCode), e.URL)
+}
+
+// Temporary reports whether request may succeed if retried later
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func newStatusError(URL string, response *http.Response) *StatusError {
+	return &StatusError{
+		URL:        URL,
+		StatusCode: response.StatusCode,
+		RetryAfter: parseRetryAfter(response.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter understands both forms of Retry-After: delay in seconds
+// and HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// isRetryable decides if err is worth another attempt. Network errors are,
+// 429 and 5xx are, any other status (like 404) is not. Neither is being
+// cancelled.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+// delay returns how long to wait before retry number attempt (starting at 1),
+// with jitter so that parallel workers don't retry in lockstep. Delay server
+// asked for is obeyed, but not beyond MaxDelay.
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > p.MaxDelay {
+			return p.MaxDelay
+		}
+		return statusErr.RetryAfter
+	}
+	d := p.BaseDelay << uint(attempt-1)
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error, runs out of
+// attempts or ctx is done
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts || !isRetryable(err) {
+			break
+		}
+		d := p.delay(attempt, err)
+		Log.Warn("Attempt failed, retrying", Fields{"attempt": attempt, "max_attempts": p.MaxAttempts, "delay": d.Round(time.Second), "error": err})
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return err
+		}
+	}
+	return err
+}
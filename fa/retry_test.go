@@ -0,0 +1,29 @@
+package fa
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 2 * time.Minute}
+	tests := []struct {
+		attempt  int
+		err      error
+		min, max time.Duration
+	}{
+		{1, errors.New("connection reset"), time.Second, 2 * time.Second},
+		{3, errors.New("connection reset"), 4 * time.Second, 8 * time.Second},
+		{20, errors.New("connection reset"), time.Minute, 2 * time.Minute},
+		{1, &StatusError{StatusCode: 429, RetryAfter: 30 * time.Second}, 30 * time.Second, 30 * time.Second},
+		// a day long Retry-After doesn't stall a worker for a day
+		{1, &StatusError{StatusCode: 503, RetryAfter: 24 * time.Hour}, 2 * time.Minute, 2 * time.Minute},
+	}
+	for _, test := range tests {
+		d := policy.delay(test.attempt, test.err)
+		if d < test.min || d > test.max {
+			t.Errorf("delay(%d, %v) = %v, want between %v and %v", test.attempt, test.err, d, test.min, test.max)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package fa
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// NotifyShutdown handles SIGINT and SIGTERM. The first one cancels stop,
+// meaning nothing new should be started but what's in progress may finish.
+// The second one cancels abort, meaning everything should stop right away.
+func NotifyShutdown() (stop context.Context, abort context.Context) {
+	stop, cancelStop := context.WithCancel(context.Background())
+	abort, cancelAbort := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		Log.Warn("Stopping once downloads in progress finish, interrupt again to abort them", nil)
+		cancelStop()
+		<-signals
+		Log.Warn("Aborting", nil)
+		cancelAbort()
+		signal.Stop(signals)
+	}()
+	return stop, abort
+}
+
+// contextTransport attaches a context to requests made by surf browser,
+// which has no way of passing one itself. Browser is only ever used by one
+// goroutine at a time, so context is simply switched before every page load.
+type contextTransport struct {
+	base http.RoundTripper
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (t *contextTransport) use(ctx context.Context) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ctx = ctx
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	ctx := t.ctx
+	t.mu.Unlock()
+	if ctx != nil {
+		req = req.WithContext(ctx)
+	}
+	return t.base.RoundTrip(req)
+}
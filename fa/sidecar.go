@@ -0,0 +1,82 @@
+package fa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// SidecarJSON makes Download write <filename>.json next to every image
+const SidecarJSON = "json"
+
+// sidecar is what gets written into <filename>.json
+type sidecar struct {
+	PageURL      string     `json:"page_url"`
+	ImageURL     string     `json:"image_url"`
+	ID           int64      `json:"id,omitempty"`
+	Artist       string     `json:"artist"`
+	Title        string     `json:"title"`
+	Posted       *time.Time `json:"posted,omitempty"`
+	Tags         []string   `json:"tags"`
+	Rating       string     `json:"rating"`
+	Description  string     `json:"description"`
+	DownloadedAt time.Time  `json:"downloaded_at"`
+}
+
+// writeSidecar saves submission's metadata next to the image at filepath.
+// Like the image itself it's written to a temporary file first and renamed
+// into place, so there's never a half-written sidecar.
+func writeSidecar(filepath string, sub *Submission) error {
+	data := sidecar{
+		PageURL:      sub.PageURL.String(),
+		ImageURL:     sub.ImageURL.String(),
+		ID:           sub.ID,
+		Artist:       sub.Artist,
+		Title:        sub.Title,
+		Tags:         sub.Tags,
+		Rating:       sub.Rating,
+		Description:  sub.Description,
+		DownloadedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if posted := sub.Posted(); !posted.IsZero() {
+		data.Posted = &posted
+	}
+	if data.Tags == nil {
+		data.Tags = []string{}
+	}
+	encoded, err := json.MarshalIndent(&data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode sidecar for %s: %w", filepath, err)
+	}
+
+	sidecarpath := filepath + ".json"
+	err = ioutil.WriteFile(sidecarpath+".download", append(encoded, '\n'), 0666)
+	if err != nil {
+		return fmt.Errorf("Failed to write sidecar '%s': %w", sidecarpath, err)
+	}
+	err = os.Rename(sidecarpath+".download", sidecarpath)
+	if err != nil {
+		return fmt.Errorf("Failed to rename %s to %s: %w", sidecarpath+".download", sidecarpath, err)
+	}
+	return nil
+}
+
+// withStoredMetadata fills in metadata of a submission whose page wasn't
+// parsed this run (like when retrying a failed image) from the database
+func (c *Client) withStoredMetadata(sub *Submission) *Submission {
+	if sub.ID != 0 {
+		return sub
+	}
+	stored, err := c.Store.SubmissionByPage(sub.PageURL)
+	if err != nil || stored == nil {
+		return sub
+	}
+	stored.ImageURL = sub.ImageURL
+	stored.PageType = sub.PageType
+	if stored.Artist == "" {
+		stored.Artist = sub.Artist
+	}
+	return stored
+}
@@ -0,0 +1,127 @@
+package fa
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Source is where a submission was found while scanning: in which artist's
+// gallery, scraps or favorites
+type Source struct {
+	SubmissionID int64
+	PageURL      *url.URL
+	Artist       string
+	PageType     string
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	// Filename is of the downloaded image, empty if it isn't downloaded
+	Filename string
+}
+
+// RecordSources remembers that submission pages were seen on artist's page
+// of pageType. Pages that aren't submissions are ignored.
+func (s *Store) RecordSources(pages []*url.URL, artist string, pageType string) (err error) {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+	defer sqlitex.Save(db)(&err)
+
+	now := time.Now().Unix()
+	for _, page := range pages {
+		id, ok := SubmissionID(page)
+		if !ok {
+			continue
+		}
+		err = sqlitex.Exec(db, `INSERT INTO sources (submission_id, artist_scanned, page_type, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(submission_id, artist_scanned, page_type) DO UPDATE SET last_seen = excluded.last_seen`,
+			nil, id, strings.ToLower(artist), pageType, now, now)
+		if err != nil {
+			return fmt.Errorf("Couldn't execute SQL query for recording source: %w", err)
+		}
+	}
+	return nil
+}
+
+// SourcesOf returns where submission was found, first found first
+func (s *Store) SourcesOf(pageURL *url.URL) ([]*Source, error) {
+	id, ok := SubmissionID(pageURL)
+	if !ok {
+		return []*Source{}, nil
+	}
+	return s.sources("WHERE sources.submission_id = ? ORDER BY first_seen", id)
+}
+
+// SourcesByArtist returns submissions found on artist's pages, of pageType
+// if it isn't empty, first found first
+func (s *Store) SourcesByArtist(artist string, pageType string) ([]*Source, error) {
+	if pageType == "" {
+		return s.sources("WHERE artist_scanned = ? ORDER BY first_seen, submission_id", strings.ToLower(artist))
+	}
+	return s.sources("WHERE artist_scanned = ? AND page_type = ? ORDER BY first_seen, submission_id", strings.ToLower(artist), pageType)
+}
+
+func (s *Store) sources(where string, args ...interface{}) ([]*Source, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	sources := []*Source{}
+	fn := func(stmt *sqlite.Stmt) error {
+		id := stmt.ColumnInt64(0)
+		pageURL, err := url.Parse(fmt.Sprintf("%s/view/%d/", URLbase, id))
+		if err != nil {
+			return err
+		}
+		sources = append(sources, &Source{
+			SubmissionID: id,
+			PageURL:      pageURL,
+			Artist:       stmt.ColumnText(1),
+			PageType:     stmt.ColumnText(2),
+			FirstSeen:    time.Unix(stmt.ColumnInt64(3), 0),
+			LastSeen:     time.Unix(stmt.ColumnInt64(4), 0),
+			Filename:     stmt.ColumnText(5),
+		})
+		return nil
+	}
+	// image_urls are keyed by page path
+	err = sqlitex.Exec(db, `SELECT sources.submission_id, artist_scanned, page_type, first_seen, last_seen, image_urls.filename FROM sources
+		LEFT JOIN image_urls ON image_urls.page_url = '/view/' || sources.submission_id || '/' `+where, fn, args...)
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// withSource fills in where submission was found when that isn't known,
+// like when retrying failures, from where it was found first. Folders found
+// by scanning them fill in for metadata that was never stored.
+func (c *Client) withSource(sub *Submission) {
+	if len(sub.Folders) == 0 {
+		folders, err := c.Store.FoldersOf(sub.PageURL)
+		if err != nil {
+			Log.Warn("Failed to get folders of submission", PageFields(sub.PageURL).With(Fields{"error": err}))
+		} else {
+			sub.Folders = folders
+		}
+	}
+	if sub.PageType != "" {
+		return
+	}
+	sources, err := c.Store.SourcesOf(sub.PageURL)
+	if err != nil {
+		Log.Warn("Failed to get sources of submission", PageFields(sub.PageURL).With(Fields{"error": err}))
+		return
+	}
+	if len(sources) > 0 {
+		sub.PageType = sources[0].PageType
+	}
+}
@@ -0,0 +1,268 @@
+package fa
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// DatabaseFilename is the name of the database inside config directory
+const DatabaseFilename = "downloaded.sqlite"
+
+// Store is the database of already downloaded images
+type Store struct {
+	pool *sqlitex.Pool
+	ctx  context.Context
+}
+
+// OpenStore opens (and creates if needed) the database at filepath. Its
+// schema isn't touched until Migrate is called. Once ctx is done, queries
+// in progress are interrupted and new ones fail.
+func OpenStore(ctx context.Context, filepath string) (*Store, error) {
+	pool, err := sqlitex.Open(filepath, 0, 100)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database %s: %w", filepath, err)
+	}
+	s := &Store{pool: pool, ctx: ctx}
+
+	db, err := s.get()
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	for _, statement := range []string{
+		"PRAGMA cache_size = 1000000",
+		"PRAGMA temp_store = MEMORY",
+		"PRAGMA synchronous = OFF",
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA busy_timeout = 500000",
+		"PRAGMA optimize",
+		"PRAGMA vacuum",
+	} {
+		err = sqlitex.ExecTransient(db, statement, nil)
+		if err != nil {
+			s.put(db)
+			pool.Close()
+			return nil, fmt.Errorf("Failed to execute statement %s: %w", statement, err)
+		}
+	}
+	s.put(db)
+	Log.Debug("Opened database", Fields{"file": filepath})
+	return s, nil
+}
+
+// openStoreReadOnly opens existing database at filepath without writing
+// anything to it, not even journal mode or pragmas
+func openStoreReadOnly(ctx context.Context, filepath string) (*Store, error) {
+	pool, err := sqlitex.Open(filepath, sqlite.SQLITE_OPEN_READONLY|sqlite.SQLITE_OPEN_URI|sqlite.SQLITE_OPEN_NOMUTEX, 1)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database %s: %w", filepath, err)
+	}
+	return &Store{pool: pool, ctx: ctx}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.pool.Close()
+}
+
+func (s *Store) get() (*sqlite.Conn, error) {
+	db := s.pool.Get(s.ctx)
+	if db == nil {
+		return nil, fmt.Errorf("Couldn't get db from dbpool")
+	}
+	return db, nil
+}
+
+func (s *Store) put(db *sqlite.Conn) {
+	s.pool.Put(db)
+}
+
+// IsDownloaded checks if image from submission page is already in database
+func (s *Store) IsDownloaded(pageURL *url.URL) (bool, error) {
+	db, err := s.get()
+	if err != nil {
+		return false, err
+	}
+	defer s.put(db)
+
+	dbkey := pageURL.Path
+	var filename string
+	fn := func(stmt *sqlite.Stmt) error {
+		filename = stmt.ColumnText(0)
+		return nil
+	}
+	err = sqlitex.Exec(db, "SELECT filename FROM image_urls WHERE page_url = ? LIMIT 1", fn, dbkey)
+	if err != nil {
+		return false, err
+	} else if filename != "" {
+		return true, nil
+	}
+	return false, nil
+}
+
+// FilenameOwner finds which submission page's image is saved as filename.
+// It returns nil if none is.
+func (s *Store) FilenameOwner(filename string) (*url.URL, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	var owner *url.URL
+	fn := func(stmt *sqlite.Stmt) error {
+		owner, err = url.Parse(URLbase + stmt.ColumnText(0))
+		return err
+	}
+	err = sqlitex.Exec(db, "SELECT page_url FROM image_urls WHERE filename = ? LIMIT 1", fn, filename)
+	if err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
+
+// Image is a downloaded image as recorded in the database
+type Image struct {
+	PageURL      *url.URL
+	ImageURL     *url.URL
+	LastModified time.Time
+	// Filename is relative to the download directory
+	Filename string
+	// Size is of the file as saved
+	Size int64
+	// OriginalSHA256 is the checksum of the image as downloaded, SHA256 of
+	// the file as saved (they differ when metadata was embedded into it)
+	OriginalSHA256 string
+	SHA256         string
+}
+
+// SetImage records that image from submission page was saved
+func (s *Store) SetImage(image *Image) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	dbkey := image.PageURL.Path
+	stmt, err := db.Prepare("INSERT OR REPLACE INTO image_urls (page_url, image_url, last_modified, filename, size, original_sha256, sha256) VALUES ($page_url, $image_url, $last_modified, $filename, $size, $original_sha256, $sha256)")
+	if err != nil {
+		return fmt.Errorf("Couldn't prepare SQL query for setting image url: %w", err)
+	}
+	stmt.SetText("$page_url", dbkey)
+	stmt.SetText("$image_url", image.ImageURL.String())
+	if image.LastModified.IsZero() {
+		stmt.SetNull("$last_modified")
+	} else {
+		stmt.SetInt64("$last_modified", image.LastModified.Unix())
+	}
+	stmt.SetText("$filename", image.Filename)
+	stmt.SetInt64("$size", image.Size)
+	stmt.SetText("$original_sha256", image.OriginalSHA256)
+	stmt.SetText("$sha256", image.SHA256)
+	for {
+		if hasRow, err := stmt.Step(); err != nil {
+			return fmt.Errorf("Couldn't execute SQL query for setting image url: %w", err)
+		} else if !hasRow {
+			break
+		}
+	}
+	return nil
+}
+
+// EachImage calls fn for every downloaded image in the database
+func (s *Store) EachImage(fn func(image *Image) error) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	return sqlitex.Exec(db, "SELECT page_url, image_url, last_modified, filename, size, original_sha256, sha256 FROM image_urls ORDER BY page_url", func(stmt *sqlite.Stmt) error {
+		image, err := scanImage(stmt)
+		if err != nil {
+			return err
+		}
+		return fn(image)
+	})
+}
+
+// DeleteImage forgets that image from submission page was downloaded
+func (s *Store) DeleteImage(pageURL *url.URL) error {
+	db, err := s.get()
+	if err != nil {
+		return err
+	}
+	defer s.put(db)
+
+	return sqlitex.Exec(db, "DELETE FROM image_urls WHERE page_url = ?", nil, pageURL.Path)
+}
+
+func scanImage(stmt *sqlite.Stmt) (*Image, error) {
+	// page_url is stored as path only
+	pageURL, err := url.Parse(URLbase + stmt.ColumnText(0))
+	if err != nil {
+		return nil, err
+	}
+	imageURL, err := url.Parse(stmt.ColumnText(1))
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{
+		PageURL:        pageURL,
+		ImageURL:       imageURL,
+		Filename:       stmt.ColumnText(3),
+		Size:           stmt.ColumnInt64(4),
+		OriginalSHA256: stmt.ColumnText(5),
+		SHA256:         stmt.ColumnText(6),
+	}
+	if stmt.ColumnType(2) != sqlite.SQLITE_NULL {
+		image.LastModified = time.Unix(stmt.ColumnInt64(2), 0)
+	}
+	return image, nil
+}
+
+// Stats is a summary of what's in the database
+type Stats struct {
+	Images      int
+	Bytes       int64
+	Submissions int
+	Failures    int
+	GivenUp     int
+}
+
+// Stats counts downloaded images, their size, submissions with metadata,
+// failures waiting to be retried and failures given up on
+func (s *Store) Stats() (*Stats, error) {
+	db, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	defer s.put(db)
+
+	stats := &Stats{}
+	err = sqlitex.Exec(db, "SELECT count(*), coalesce(sum(size), 0) FROM image_urls", func(stmt *sqlite.Stmt) error {
+		stats.Images = stmt.ColumnInt(0)
+		stats.Bytes = stmt.ColumnInt64(1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = sqlitex.Exec(db, "SELECT (SELECT count(*) FROM submissions), (SELECT count(*) FROM failures WHERE NOT given_up), (SELECT count(*) FROM failures WHERE given_up)", func(stmt *sqlite.Stmt) error {
+		stats.Submissions = stmt.ColumnInt(0)
+		stats.Failures = stmt.ColumnInt(1)
+		stats.GivenUp = stmt.ColumnInt(2)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
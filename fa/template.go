@@ -0,0 +1,217 @@
+package fa
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultPathTemplate saves images flat into download directory, named the
+// way FA names them
+const DefaultPathTemplate = "{filename}"
+
+// maxSegment keeps directory and file names under what filesystems allow
+const maxSegment = 200
+
+// placeholders path templates understand, with what they're replaced by
+var placeholders = map[string]func(sub *Submission, filename string, arg string) string{
+	"filename": func(sub *Submission, filename string, arg string) string { return filename },
+	"artist":   func(sub *Submission, filename string, arg string) string { return sub.ArtistUsername() },
+	"pagetype": func(sub *Submission, filename string, arg string) string { return sub.PageType },
+	"title":    func(sub *Submission, filename string, arg string) string { return sub.Title },
+	"rating":   func(sub *Submission, filename string, arg string) string { return sub.Rating },
+	"category": func(sub *Submission, filename string, arg string) string { return sub.Category },
+	"ext": func(sub *Submission, filename string, arg string) string {
+		return strings.TrimPrefix(path.Ext(filename), ".")
+	},
+	"id": func(sub *Submission, filename string, arg string) string {
+		id := sub.ID
+		if id == 0 {
+			id, _ = SubmissionID(sub.PageURL)
+		}
+		if id == 0 {
+			return ""
+		}
+		return strconv.FormatInt(id, 10)
+	},
+	"folder": func(sub *Submission, filename string, arg string) string {
+		if len(sub.Folders) == 0 {
+			return ""
+		}
+		return sub.Folders[0].Name
+	},
+	// slashes in date layout make directories
+	"date": func(sub *Submission, filename string, arg string) string {
+		posted := sub.Posted()
+		if posted.IsZero() {
+			return ""
+		}
+		if arg == "" {
+			arg = "2006-01-02"
+		}
+		parts := strings.Split(posted.Format(arg), "/")
+		for i, part := range parts {
+			parts[i] = sanitizeName(part)
+		}
+		return strings.Join(parts, "/")
+	},
+}
+
+// PathTemplate lays out downloaded images in download directory, like
+// "{artist}/{pagetype}/{id}_{title}.{ext}". Directories that end up empty,
+// like {folder} of a submission that isn't in one, are left out.
+type PathTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text or a placeholder with its argument
+type templatePart struct {
+	literal string
+	name    string
+	arg     string
+}
+
+// ParsePathTemplate checks template and prepares it for Render
+func ParsePathTemplate(template string) (*PathTemplate, error) {
+	if template == "" {
+		template = DefaultPathTemplate
+	}
+	if strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("Path template %s must be relative to download directory", template)
+	}
+	t := &PathTemplate{}
+	literal := ""
+	for rest := template; rest != ""; {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			literal += rest
+			break
+		}
+		literal += rest[:open]
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("Path template %s has unclosed {", template)
+		}
+		name := rest[open+1 : open+end]
+		arg := ""
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			name, arg = name[:i], name[i+1:]
+		}
+		if _, ok := placeholders[name]; !ok {
+			return nil, fmt.Errorf("Path template %s has unknown placeholder {%s}", template, name)
+		}
+		if literal != "" {
+			t.parts = append(t.parts, templatePart{literal: literal})
+			literal = ""
+		}
+		t.parts = append(t.parts, templatePart{name: name, arg: arg})
+		rest = rest[open+end+1:]
+	}
+	if literal != "" {
+		t.parts = append(t.parts, templatePart{literal: literal})
+	}
+	for _, part := range t.parts {
+		for _, segment := range strings.Split(part.literal, "/") {
+			if segment == ".." {
+				return nil, fmt.Errorf("Path template %s must stay inside download directory", template)
+			}
+		}
+	}
+	return t, nil
+}
+
+// Render makes path of submission's image, relative to download directory.
+// Filename is what FA named the image.
+func (t *PathTemplate) Render(sub *Submission, filename string) string {
+	var b strings.Builder
+	for _, part := range t.parts {
+		if part.name == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		value := placeholders[part.name](sub, filename, part.arg)
+		if part.name != "date" {
+			value = sanitizeName(value)
+		}
+		b.WriteString(value)
+	}
+
+	segments := []string{}
+	for _, segment := range strings.Split(b.String(), "/") {
+		segment = strings.Trim(segment, " .")
+		if segment == "" {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return sanitizeName(filename)
+	}
+	for i, segment := range segments {
+		if i == len(segments)-1 {
+			ext := path.Ext(segment)
+			segments[i] = truncateName(strings.TrimSuffix(segment, ext), maxSegment-len(ext)) + ext
+		} else {
+			segments[i] = truncateName(segment, maxSegment)
+		}
+	}
+	return path.Join(segments...)
+}
+
+// Missing lists placeholders of template that render empty for submission
+func (t *PathTemplate) Missing(sub *Submission, filename string) []string {
+	missing := []string{}
+	for _, part := range t.parts {
+		if part.name != "" && placeholders[part.name](sub, filename, part.arg) == "" {
+			missing = append(missing, part.name)
+		}
+	}
+	return missing
+}
+
+// sanitizeName replaces what isn't allowed in file names on some
+// filesystem or another
+func sanitizeName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	return strings.Trim(s, " .")
+}
+
+// truncateName cuts s to at most n bytes, without splitting a character
+func truncateName(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return strings.TrimRight(s[:n], " .")
+}
+
+// pathReservations makes sure concurrent downloads don't pick the same
+// path for different submissions
+type pathReservations struct {
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+// reserve claims fullpath for submission page, it fails if another page
+// already has
+func (r *pathReservations) reserve(fullpath string, pagePath string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	owner, ok := r.owners[fullpath]
+	if ok && owner != pagePath {
+		return false
+	}
+	r.owners[fullpath] = pagePath
+	return true
+}
@@ -0,0 +1,112 @@
+package fa
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		ok       bool
+	}{
+		{"", true},
+		{"{filename}", true},
+		{"{artist}/{pagetype}/{folder}/{id}_{title}.{ext}", true},
+		{"{date:2006/01}/{filename}", true},
+		// literal .. is rejected even where it couldn't climb out
+		{"{artist}../{filename}", false},
+		{"/{filename}", false},
+		{"{nope}/{filename}", false},
+		{"{artist/{filename}", false},
+		{"../{filename}", false},
+		{"{artist}/../{filename}", false},
+		{"{artist}/..", false},
+	}
+	for _, test := range tests {
+		_, err := ParsePathTemplate(test.template)
+		if (err == nil) != test.ok {
+			t.Errorf("ParsePathTemplate(%q) = %v, want ok %v", test.template, err, test.ok)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	pageURL, _ := url.Parse("https://www.furaffinity.net/view/38123456/")
+	imageURL, _ := url.Parse("https://d.furaffinity.net/art/tojo/1598972640/1598972640.tojo_night.png")
+	long := strings.Repeat("é", 150)
+	odd := "a" + strings.Repeat("€", 100)
+	tests := []struct {
+		template string
+		sub      Submission
+		want     string
+	}{
+		{"{filename}", Submission{}, "1598972640.tojo_night.png"},
+		{"{artist}/{pagetype}/{id}_{title}.{ext}", Submission{Artist: "tojo", PageType: "gallery", Title: "Night Market"}, "tojo/gallery/38123456_Night Market.png"},
+		// artist goes by username, whatever the page shows
+		{"{artist}/{filename}", Submission{Artist: "Tojo-The-Thief", Username: "tojo-the-thief"}, "tojo-the-thief/1598972640.tojo_night.png"},
+		{"{artist}/{filename}", Submission{Artist: "Tojo", ImageURL: imageURL}, "tojo/1598972640.tojo_night.png"},
+		{"{artist}/{filename}", Submission{Artist: "Some_Artist"}, "someartist/1598972640.tojo_night.png"},
+		// slashes and other characters filesystems don't allow
+		{"{title}/{filename}", Submission{Title: "a/b\\c:d*e?f\"g<h>i|j"}, "a_b_c_d_e_f_g_h_i_j/1598972640.tojo_night.png"},
+		{"{artist}/{title}.{ext}", Submission{Artist: "tojo", Title: "../../etc/passwd"}, "tojo/_.._etc_passwd.png"},
+		{"{title}/{filename}", Submission{Title: ".."}, "1598972640.tojo_night.png"},
+		{"{title}/{filename}", Submission{Title: "line\nbreak\ttab\x00nul"}, "line_break_tab_nul/1598972640.tojo_night.png"},
+		{"{title}/{filename}", Submission{Title: " . dots and spaces . "}, "dots and spaces/1598972640.tojo_night.png"},
+		// empty directories are left out
+		{"{artist}/{folder}/{filename}", Submission{Artist: "tojo"}, "tojo/1598972640.tojo_night.png"},
+		{"{artist}/{folder}/{filename}", Submission{Artist: "tojo", Folders: []Folder{{ID: 1, Name: "Comics/Strips"}, {ID: 2, Name: "Other"}}}, "tojo/Comics_Strips/1598972640.tojo_night.png"},
+		{"{rating}/{category}/{filename}", Submission{}, "1598972640.tojo_night.png"},
+		// nothing left at all falls back to what FA named the image
+		{"{title}", Submission{}, "1598972640.tojo_night.png"},
+		// date layout slashes make directories, posted date falls back to
+		// upload time in filename
+		{"{date:2006/01}/{filename}", Submission{PostedAt: time.Date(2020, 9, 1, 15, 4, 0, 0, time.UTC)}, "2020/09/1598972640.tojo_night.png"},
+		{"{date}/{filename}", Submission{ImageURL: imageURL}, time.Unix(1598972640, 0).Format("2006-01-02") + "/1598972640.tojo_night.png"},
+		// long names are cut without splitting characters, keeping extension
+		{"{title}/{filename}", Submission{Title: long}, strings.Repeat("é", 100) + "/1598972640.tojo_night.png"},
+		{"{title}.{ext}", Submission{Title: long}, strings.Repeat("é", 98) + ".png"},
+		{"{title}/{filename}", Submission{Title: odd}, "a" + strings.Repeat("€", 66) + "/1598972640.tojo_night.png"},
+	}
+	for _, test := range tests {
+		template, err := ParsePathTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub := test.sub
+		sub.PageURL = pageURL
+		got := template.Render(&sub, "1598972640.tojo_night.png")
+		if got != test.want {
+			t.Errorf("Render(%q) with %+v = %q, want %q", test.template, test.sub, got, test.want)
+		}
+		for _, segment := range strings.Split(got, "/") {
+			if len(segment) > maxSegment || !utf8.ValidString(segment) || segment == ".." || segment == "." || segment == "" {
+				t.Errorf("Render(%q) made bad path segment %q", test.template, segment)
+			}
+		}
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"abcdef", 3, "abc"},
+		{"ééé", 3, "é"},
+		{"ééé", 1, ""},
+		{"a€b", 3, "a"},
+		{"ab. cd", 4, "ab"},
+	}
+	for _, test := range tests {
+		if got := truncateName(test.s, test.n); got != test.want {
+			t.Errorf("truncateName(%q, %d) = %q, want %q", test.s, test.n, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package fa
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of problems Verify finds
+const (
+	ProblemMissing = "missing"
+	ProblemSize    = "size mismatch"
+	ProblemHash    = "hash mismatch"
+	ProblemOrphan  = "orphan"
+)
+
+// Problem is something wrong with a file in download directory
+type Problem struct {
+	Kind string
+	// Filename is relative to the download directory
+	Filename string
+	// Image is the database row of the file, nil for orphans
+	Image  *Image
+	Detail string
+}
+
+// Verify checks every downloaded image against its database row, and looks
+// for files in download directory that the database doesn't know about.
+// Broken files that Repair moved aside aren't reported.
+func (c *Client) Verify() ([]*Problem, error) {
+	problems := []*Problem{}
+	known := map[string]bool{}
+
+	err := c.Store.EachImage(func(image *Image) error {
+		known[image.Filename] = true
+		filepath := c.imagePath(image.Filename)
+		stat, err := os.Stat(filepath)
+		if os.IsNotExist(err) {
+			problems = append(problems, &Problem{Kind: ProblemMissing, Filename: image.Filename, Image: image})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if image.Size > 0 && stat.Size() != image.Size {
+			problems = append(problems, &Problem{
+				Kind:     ProblemSize,
+				Filename: image.Filename,
+				Image:    image,
+				Detail:   fmt.Sprintf("%d bytes on disk, %d expected", stat.Size(), image.Size),
+			})
+			return nil
+		}
+		// images downloaded before checksums were kept can't be checked
+		if image.SHA256 == "" {
+			return nil
+		}
+		sum, _, err := hashFile(filepath)
+		if err != nil {
+			return err
+		}
+		if sum != image.SHA256 {
+			problems = append(problems, &Problem{
+				Kind:     ProblemHash,
+				Filename: image.Filename,
+				Image:    image,
+				Detail:   fmt.Sprintf("sha256 %s on disk, %s expected", sum, image.SHA256),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to check images in database: %w", err)
+	}
+
+	err = filepath.Walk(c.root, func(fullpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(c.root, fullpath)
+		if err != nil {
+			return err
+		}
+		filename := filepath.ToSlash(rel)
+		if known[filename] {
+			return nil
+		}
+		// sidecars and unfinished downloads belong to their image, and
+		// files Repair moved aside are already taken care of
+		if strings.HasSuffix(filename, ".download") || strings.HasSuffix(filename, ".corrupt") {
+			return nil
+		}
+		if strings.HasSuffix(filename, ".json") && known[strings.TrimSuffix(filename, ".json")] {
+			return nil
+		}
+		problems = append(problems, &Problem{Kind: ProblemOrphan, Filename: filename})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Failed to walk download directory %s: %w", c.root, err)
+	}
+	return problems, nil
+}
+
+// Repair queues image with a problem to be downloaded again by moving the
+// broken file out of the way, forgetting it was downloaded and recording it
+// as a failure, so that the next run (or retry-failed) gets it again.
+// Orphans can't be repaired.
+func (c *Client) Repair(problem *Problem) error {
+	if problem.Image == nil {
+		return fmt.Errorf("Can't repair %s, it's not in database", problem.Filename)
+	}
+	if problem.Kind != ProblemMissing {
+		filepath := c.imagePath(problem.Filename)
+		err := os.Rename(filepath, filepath+".corrupt")
+		if err != nil {
+			return fmt.Errorf("Failed to move %s out of the way: %w", problem.Filename, err)
+		}
+	}
+	err := c.Store.DeleteImage(problem.Image.PageURL)
+	if err != nil {
+		return fmt.Errorf("Failed to delete %s from database: %w", problem.Filename, err)
+	}
+	err = c.Store.RecordFailure(problem.Image.PageURL, problem.Image.ImageURL, StageVerify, fmt.Errorf("%s %s", problem.Filename, problem.Kind), false)
+	if err != nil {
+		return fmt.Errorf("Failed to queue %s for download: %w", problem.Filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package fa
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestVerifyAndRepair(t *testing.T) {
+	client := testClient(t, tempDir(t), URLbase)
+	root := client.options.DownloadDirectory
+	err := os.MkdirAll(path.Join(root, "tojo"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("image"))
+	good := hex.EncodeToString(sum[:])
+
+	files := map[string]string{
+		"tojo/ok.png":           "image",
+		"tojo/size.png":         "imag",
+		"tojo/hash.png":         "imagE",
+		"tojo/orphan.png":       "image",
+		"tojo/new.png.download": "ima",
+	}
+	for filename, content := range files {
+		err = ioutil.WriteFile(path.Join(root, filename), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, filename := range []string{"tojo/ok.png", "tojo/missing.png", "tojo/size.png", "tojo/hash.png"} {
+		pageURL, _ := url.Parse(URLbase + "/view/" + strconv.Itoa(i+1) + "/")
+		imageURL, _ := url.Parse("https://d.furaffinity.net/art/tojo/1598972640/" + path.Base(filename))
+		err = client.Store.SetImage(&Image{PageURL: pageURL, ImageURL: imageURL, Filename: filename, Size: 5, OriginalSHA256: good, SHA256: good})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := client.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	var hashProblem *Problem
+	for _, problem := range problems {
+		got[problem.Filename] = problem.Kind
+		if problem.Kind == ProblemHash {
+			hashProblem = problem
+		}
+	}
+	want := map[string]string{
+		"tojo/missing.png": ProblemMissing,
+		"tojo/size.png":    ProblemSize,
+		"tojo/hash.png":    ProblemHash,
+		"tojo/orphan.png":  ProblemOrphan,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Verify found %v, want %v", got, want)
+	}
+
+	err = client.Repair(hashProblem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(root, "tojo/hash.png.corrupt")); err != nil {
+		t.Errorf("Broken file wasn't moved aside: %v", err)
+	}
+	failures, err := client.Store.Failures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Stage != StageVerify || failures[0].GivenUp {
+		t.Errorf("Got failures %+v, want repaired image queued for retry", failures)
+	}
+
+	// moved aside file isn't an orphan
+	problems, err = client.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filenames := []string{}
+	for _, problem := range problems {
+		filenames = append(filenames, problem.Filename)
+	}
+	sort.Strings(filenames)
+	if want := []string{"tojo/missing.png", "tojo/orphan.png", "tojo/size.png"}; !reflect.DeepEqual(filenames, want) {
+		t.Errorf("Verify after repair found %v, want %v", filenames, want)
+	}
+}
@@ -0,0 +1,217 @@
+package fa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var jpegSignature = []byte{0xFF, 0xD8}
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// XMP packets in JPEG live in an APP1 segment starting with this namespace
+var jpegXMPHeader = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// maxJPEGPacket is the largest XMP packet that fits into a JPEG segment
+var maxJPEGPacket = 0xFFFF - 2 - len(jpegXMPHeader)
+
+// and in PNG in an iTXt chunk with this keyword
+const pngXMPKeyword = "XML:com.adobe.xmp"
+
+// errUnsupportedFormat means file isn't a JPEG or PNG, so nothing is embedded
+var errUnsupportedFormat = errors.New("Unsupported file format for embedding metadata")
+
+// embedMetadata writes submission's title, artist, tags, source URL and
+// description as XMP into JPEG or PNG file at filepath. Only the metadata
+// segment is added (or replaced), image data is copied as is. File is
+// rewritten through a temporary file, like downloads are.
+func embedMetadata(filepath string, sub *Submission) error {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return err
+	}
+	description := plainText(sub.Description)
+
+	var embedded []byte
+	switch {
+	case bytes.HasPrefix(data, jpegSignature):
+		// long descriptions are cut so that packet fits into a segment,
+		// escaping makes packet grow faster than description, so cut
+		// at least by half when overflow is larger than that
+		packet := buildXMP(sub, description)
+		for len(packet) > maxJPEGPacket && description != "" {
+			n := len(description) - (len(packet) - maxJPEGPacket)
+			if n > len(description)/2 {
+				description = truncateName(description, n)
+			} else {
+				description = truncateName(description, len(description)/2)
+			}
+			packet = buildXMP(sub, description)
+		}
+		embedded, err = embedJPEG(data, packet)
+	case bytes.HasPrefix(data, pngSignature):
+		embedded, err = embedPNG(data, buildXMP(sub, description))
+	default:
+		return errUnsupportedFormat
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to embed metadata into %s: %w", filepath, err)
+	}
+
+	err = ioutil.WriteFile(filepath+".download", embedded, 0666)
+	if err != nil {
+		os.Remove(filepath + ".download")
+		return fmt.Errorf("Failed to write '%s': %w", filepath+".download", err)
+	}
+	err = os.Rename(filepath+".download", filepath)
+	if err != nil {
+		return fmt.Errorf("Failed to rename %s to %s: %w", filepath+".download", filepath, err)
+	}
+	return nil
+}
+
+// buildXMP makes an XMP packet with Dublin Core properties of submission,
+// description is already stripped of HTML
+func buildXMP(sub *Submission, description string) []byte {
+	var b bytes.Buffer
+	esc := func(s string) string {
+		var e bytes.Buffer
+		xml.EscapeText(&e, []byte(s))
+		return e.String()
+	}
+
+	b.WriteString("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	b.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	b.WriteString("  <rdf:Description rdf:about=\"\" xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n")
+	if sub.Title != "" {
+		fmt.Fprintf(&b, "   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", esc(sub.Title))
+	}
+	if sub.Artist != "" {
+		fmt.Fprintf(&b, "   <dc:creator><rdf:Seq><rdf:li>%s</rdf:li></rdf:Seq></dc:creator>\n", esc(sub.Artist))
+	}
+	if len(sub.Tags) > 0 {
+		b.WriteString("   <dc:subject><rdf:Bag>")
+		for _, tag := range sub.Tags {
+			fmt.Fprintf(&b, "<rdf:li>%s</rdf:li>", esc(tag))
+		}
+		b.WriteString("</rdf:Bag></dc:subject>\n")
+	}
+	if description != "" {
+		fmt.Fprintf(&b, "   <dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", esc(description))
+	}
+	fmt.Fprintf(&b, "   <dc:source>%s</dc:source>\n", esc(sub.PageURL.String()))
+	b.WriteString("  </rdf:Description>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	b.WriteString("<?xpacket end=\"w\"?>")
+	return b.Bytes()
+}
+
+// plainText strips HTML of submission description
+func plainText(html string) string {
+	if html == "" {
+		return ""
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Text())
+}
+
+// embedJPEG puts XMP packet into an APP1 segment right after the leading
+// APP0/APP1 segments, dropping any XMP segment that was already there
+func embedJPEG(data []byte, packet []byte) ([]byte, error) {
+	payload := append(append([]byte{}, jpegXMPHeader...), packet...)
+	if len(payload)+2 > 0xFFFF {
+		return nil, fmt.Errorf("XMP packet of %d bytes is too large for a JPEG segment", len(packet))
+	}
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, jpegSignature...)
+	inserted := false
+	pos := len(jpegSignature)
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("Broken JPEG segment at offset %d", pos)
+		}
+		marker := data[pos+1]
+		// image data starts here, rest of the file is copied as is
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("Broken JPEG segment at offset %d", pos)
+		}
+		isAPP0 := marker == 0xE0
+		isAPP1 := marker == 0xE1
+		isXMP := isAPP1 && bytes.HasPrefix(data[pos+4:end], jpegXMPHeader)
+		if !inserted && !isAPP0 && !(isAPP1 && !isXMP) {
+			out = append(out, segment...)
+			inserted = true
+		}
+		if !isXMP {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	if !inserted {
+		out = append(out, segment...)
+	}
+	return append(out, data[pos:]...), nil
+}
+
+// embedPNG puts XMP packet into an iTXt chunk right after IHDR, dropping
+// any XMP chunk that was already there
+func embedPNG(data []byte, packet []byte) ([]byte, error) {
+	// keyword, null separator, compression flag and method, empty language
+	// tag and translated keyword
+	chunkData := append([]byte(pngXMPKeyword), 0, 0, 0, 0, 0)
+	chunkData = append(chunkData, packet...)
+	chunk := pngChunk("iTXt", chunkData)
+
+	out := append([]byte{}, pngSignature...)
+	pos := len(pngSignature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, fmt.Errorf("Broken PNG chunk at offset %d", pos)
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		isXMP := chunkType == "iTXt" && bytes.HasPrefix(data[pos+8:end-4], append([]byte(pngXMPKeyword), 0))
+		if !isXMP {
+			out = append(out, data[pos:end]...)
+		}
+		if chunkType == "IHDR" {
+			out = append(out, chunk...)
+		}
+		pos = end
+	}
+	if pos != len(data) {
+		return nil, fmt.Errorf("Trailing garbage after PNG chunks at offset %d", pos)
+	}
+	return out, nil
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], chunkType)
+	chunk = append(chunk, data...)
+	crc := crc32.ChecksumIEEE(chunk[4:])
+	return append(chunk, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
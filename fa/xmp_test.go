@@ -0,0 +1,188 @@
+package fa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	return img
+}
+
+func testSubmission(title string) *Submission {
+	pageURL, _ := url.Parse("https://www.furaffinity.net/view/38123456/")
+	return &Submission{
+		PageURL:     pageURL,
+		Title:       title,
+		Artist:      "Tojo-The-Thief",
+		Tags:        []string{"fox", "night"},
+		Description: "A market <b>at night</b> & more",
+	}
+}
+
+// writeTestFile writes data into a temporary directory and returns its path
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	dir, err := ioutil.TempDir("", "xmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	filepath := path.Join(dir, name)
+	err = ioutil.WriteFile(filepath, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath
+}
+
+// jpegScanData returns everything from the SOS marker on
+func jpegScanData(t *testing.T, data []byte) []byte {
+	pos := len(jpegSignature)
+	for pos+4 <= len(data) {
+		if data[pos+1] == 0xDA {
+			return data[pos:]
+		}
+		pos += 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+	}
+	t.Fatal("No SOS marker in JPEG")
+	return nil
+}
+
+// pngChunks returns data of every chunk of chunkType
+func pngChunks(data []byte, chunkType string) [][]byte {
+	chunks := [][]byte{}
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		if string(data[pos+4:pos+8]) == chunkType {
+			chunks = append(chunks, data[pos+8:pos+8+length])
+		}
+		pos += 12 + length
+	}
+	return chunks
+}
+
+func TestEmbedJPEG(t *testing.T) {
+	var b bytes.Buffer
+	err := jpeg.Encode(&b, testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := b.Bytes()
+	filepath := writeTestFile(t, "image.jpg", original)
+
+	for _, title := range []string{"First title", "Second title"} {
+		err = embedMetadata(filepath, testSubmission(title))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Embedded JPEG doesn't decode: %v", err)
+		}
+		if n := bytes.Count(data, jpegXMPHeader); n != 1 {
+			t.Errorf("Got %d XMP segments after embedding %q, want 1", n, title)
+		}
+		if !bytes.Contains(data, []byte(title)) {
+			t.Errorf("Embedded JPEG doesn't contain title %q", title)
+		}
+		if !bytes.Equal(jpegScanData(t, data), jpegScanData(t, original)) {
+			t.Errorf("Scan data changed after embedding %q", title)
+		}
+	}
+}
+
+func TestEmbedJPEGLongDescription(t *testing.T) {
+	var b bytes.Buffer
+	err := jpeg.Encode(&b, testImage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, description := range []string{
+		strings.Repeat("Ünïcode & <escaped> text. ", 5000),
+		strings.Repeat(`"`, 20000),
+	} {
+		filepath := writeTestFile(t, "image.jpg", b.Bytes())
+		sub := testSubmission("Long")
+		sub.Description = description
+		err = embedMetadata(filepath, sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Embedded JPEG doesn't decode: %v", err)
+		}
+		if !bytes.Contains(data, []byte("<dc:description>")) {
+			t.Errorf("Cut description wasn't embedded")
+		}
+	}
+}
+
+func TestEmbedPNG(t *testing.T) {
+	var b bytes.Buffer
+	err := png.Encode(&b, testImage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := b.Bytes()
+	filepath := writeTestFile(t, "image.png", original)
+
+	for _, title := range []string{"First title", "Second title"} {
+		err = embedMetadata(filepath, testSubmission(title))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Embedded PNG doesn't decode: %v", err)
+		}
+		xmp := 0
+		for _, chunk := range pngChunks(data, "iTXt") {
+			if bytes.HasPrefix(chunk, []byte(pngXMPKeyword+"\x00")) {
+				xmp++
+			}
+		}
+		if xmp != 1 {
+			t.Errorf("Got %d XMP chunks after embedding %q, want 1", xmp, title)
+		}
+		if !bytes.Contains(data, []byte(title)) {
+			t.Errorf("Embedded PNG doesn't contain title %q", title)
+		}
+		if got, want := bytes.Join(pngChunks(data, "IDAT"), nil), bytes.Join(pngChunks(original, "IDAT"), nil); !bytes.Equal(got, want) {
+			t.Errorf("Image data changed after embedding %q", title)
+		}
+	}
+}
+
+func TestEmbedUnsupported(t *testing.T) {
+	filepath := writeTestFile(t, "image.gif", []byte("GIF89a"))
+	if err := embedMetadata(filepath, testSubmission("Title")); err != errUnsupportedFormat {
+		t.Errorf("Got %v for GIF, want errUnsupportedFormat", err)
+	}
+}
@@ -5,479 +5,301 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
-	"regexp"
-	"sort"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
-	"crawshaw.io/sqlite"
-	"crawshaw.io/sqlite/sqlitex"
-	"github.com/fvbommel/sortorder"
-	"github.com/headzoo/surf"
+	"github.com/afurry/fadownloader/fa"
 	"github.com/jessevdk/go-flags"
-	"github.com/juju/persistent-cookiejar"
-	"github.com/kirsle/configdir"
-	"github.com/mitchellh/go-homedir"
-	"go.uber.org/ratelimit"
 
 	_ "net/http/pprof"
 )
 
-func isResponseOK(response *http.Response) bool {
-	switch response.StatusCode {
-	case 200:
-		return true
-	}
-	fmt.Printf("Got response %+v\n", response)
-	return false
-}
-
-func openURL(URL string) error {
-	now := rl.Take()
-	last = now
-
-	err := bow.Open(URL)
-	if err != nil {
-		return err
-	}
-
-	if !isResponseOK(bow.State().Response) {
-		return fmt.Errorf("Response is not ok")
-	}
-	return nil
-}
-
 var opts struct {
-	NoFastScan        bool   `long:"no-fast-scan" description:"Disable fast scanning for artist's images"`
-	NoGrabGallery     bool   `short:"g" long:"no-grab-gallery" description:"Don't grab artist's gallery"`
-	GrabFavourites    bool   `short:"f" long:"grab-favourites" description:"Grab artist's favourites"`
-	GrabScraps        bool   `short:"s" long:"grab-scraps" description:"Grab artist's scraps"`
 	Help              bool   `short:"h" long:"help" description:"Display this help message"`
 	ConfigDir         string `short:"c" long:"config-directory" description:"Specify config directory" value-name:"dir"`
 	DownloadDirectory string `short:"d" long:"download-directory" description:"Specify download directory" value-name:"dir" default:"~/Pictures/FADownloader"`
+	CDNRate           int    `long:"cdn-rate" description:"Maximum requests per second to the image server" value-name:"N" default:"10"`
+	Sidecar           string `long:"sidecar" description:"Write metadata file next to every image" choice:"json" value-name:"format"`
+	EmbedMetadata     bool   `long:"embed-metadata" description:"Write title, artist, tags and description into JPEG and PNG files as XMP"`
+	LogFormat         string `long:"log-format" description:"Log format" choice:"text" choice:"json" default:"text"`
+	LogLevel          string `long:"log-level" description:"Only log events this important or more" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info"`
+	LogFile           string `long:"log-file" description:"Append log to file instead of writing it to standard output" value-name:"file"`
+	PathTemplate      string `long:"path-template" description:"Where to save images inside download directory, placeholders are {artist}, {pagetype}, {folder}, {id}, {title}, {ext}, {filename}, {date:2006/01}, {rating} and {category}" value-name:"template" default:"{filename}"`
+	NoProgress        bool   `long:"no-progress" description:"Don't show progress bars, even when output is a terminal"`
+
+	Gallery struct {
+		scanOptions
+		Args struct {
+			Artists []string `positional-arg-name:"artist" required:"1"`
+		} `positional-args:"yes" required:"yes"`
+	} `command:"gallery" description:"Download artists' galleries"`
+	Sync struct {
+		scanOptions
+	} `command:"sync" description:"Download all artists listed in config file"`
+	RetryFailed struct {
+		scanOptions
+	} `command:"retry-failed" description:"Only retry images that failed on previous runs"`
+	Watchlist struct{} `command:"watchlist" description:"Download new submissions from watchlist"`
+	DB        struct {
+		Migrate struct {
+			DryRun bool `long:"dry-run" description:"Print pending database migrations and exit"`
+		} `command:"migrate" description:"Migrate database to current schema"`
+		Stats    struct{} `command:"stats" description:"Count downloaded images, submissions and failures"`
+		Failures struct{} `command:"failures" description:"List images that failed, and whether they'll be retried"`
+		Forget   struct {
+			Args struct {
+				Pages []string `positional-arg-name:"page-url" required:"1"`
+			} `positional-args:"yes" required:"yes"`
+		} `command:"forget" description:"Make submissions count as not downloaded"`
+		Sources struct {
+			PageType string `long:"page-type" description:"Only list submissions found on this kind of page" choice:"gallery" choice:"scraps" choice:"favorites"`
+			Args     struct {
+				Artist string `positional-arg-name:"artist"`
+			} `positional-args:"yes" required:"yes"`
+		} `command:"sources" description:"List submissions found on artist's gallery, scraps or favorites"`
+		Artists struct{} `command:"artists" description:"List how scanning of every artist went last time"`
+	} `command:"db" description:"Query and maintain the database"`
+	Verify struct {
+		Repair bool `long:"repair" description:"Move broken files aside and queue them for download again"`
+	} `command:"verify" description:"Check downloaded files against sizes and checksums in database"`
+	Login struct {
+		Username string `short:"u" long:"username" description:"FurAffinity username, asked for if not given" value-name:"name"`
+	} `command:"login" description:"Log in and save the session into cookie jar"`
+	Cookies struct {
+		Import struct {
+			Args struct {
+				File string `positional-arg-name:"cookies.txt"`
+			} `positional-args:"yes" required:"yes"`
+		} `command:"import" description:"Load FA session cookies from Netscape cookies.txt"`
+		Export struct {
+			Args struct {
+				File string `positional-arg-name:"cookies.txt"`
+			} `positional-args:"yes"`
+		} `command:"export" description:"Write cookie jar as Netscape cookies.txt, to standard output if no file is given"`
+	} `command:"cookies" description:"Import and export the session cookies"`
+	Reorganize struct {
+		DryRun bool `long:"dry-run" description:"Print where images would be moved without moving them"`
+	} `command:"reorganize" description:"Move downloaded images to where --path-template says they go"`
+	Export struct {
+		Format string `long:"format" description:"Output format" choice:"json" choice:"csv" default:"json"`
+		Output string `short:"o" long:"output" description:"Write to file instead of standard output" value-name:"file"`
+	} `command:"export" description:"Export metadata of downloaded images"`
 }
 
-var rl = ratelimit.New(3, ratelimit.WithoutSlack)
-var bow = surf.NewBrowser()
-var jar *cookiejar.Jar
-var firstTenDigits = regexp.MustCompile(`^\d{10}`)
-var brokenFilename = regexp.MustCompile(`^\d{10}\.$`)
-
 func main() {
+	os.Exit(run())
+}
+
+// run is main that returns exit code, so that deferred cleanups happen
+func run() int {
 	err := setupPprof()
 	if err == nil {
 		defer pprofListener.Close()
 	}
 	parser := flags.NewParser(&opts, flags.PrintErrors|flags.PassDoubleDash|flags.PassAfterNonOption)
 
-	// set custom usage line
-	parser.Usage = "[options] artist1 [artist2 ...]"
-
 	// update parser defaults with platform/user specific values
-	updateDefaults(parser)
-
-	// parse command line options
-	artists, err := parser.Parse()
+	err = fa.UpdateDefaults(parser)
 	if err != nil {
-		panic(err)
+		fmt.Printf("%v\n", err)
+		return exitError
 	}
 
-	// we do this to avoid having separate 'Help Options' section in help screen
-	if opts.Help {
-		parser.WriteHelp(os.Stdout)
-		os.Exit(64)
+	// config file in config directory supplies defaults for all options, so
+	// find out config directory first
+	var early struct {
+		Help      bool   `short:"h" long:"help"`
+		ConfigDir string `short:"c" long:"config-directory"`
 	}
-
-	fmt.Printf("Setting cookiejar\n")
-	{
-		cookiepath := path.Join(opts.ConfigDir, "cookies.json")
-		fmt.Printf("cookie path - %s\n", cookiepath)
-		jar, err = cookiejar.New(&cookiejar.Options{
-			Filename: cookiepath,
-		})
-		if err != nil {
-			panic(err)
-		}
-		bow.SetCookieJar(jar)
-		bow.SetUserAgent("Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_3) AppleWebKit/536.28.10 (KHTML, like Gecko) Version/6.0.3 Safari/536.28.10")
-		err = jar.Save()
-		if err != nil {
-			panic(err)
-		}
+	flags.NewParser(&early, flags.PassDoubleDash|flags.IgnoreUnknown).Parse()
+	if early.ConfigDir == "" {
+		early.ConfigDir = parser.FindOptionByLongName("config-directory").Default[0]
 	}
-	defer jar.Save()
-
-	// don't keep unlimited history, we never use the feature anyway
-	bow.HistoryJar().SetMax(1)
-
-	fmt.Printf("Opening database...")
-	dbpool, err := sqlitex.Open(path.Join(opts.ConfigDir, "downloaded.sqlite"), 0, 100)
+	config, err := fa.LoadConfig(parser, early.ConfigDir)
 	if err != nil {
-		panic(err)
+		fmt.Printf("%v\n", err)
+		return exitUsage
 	}
-	defer dbpool.Close()
-	db := dbpool.Get(nil)
-	dbMustExecute(db, "PRAGMA cache_size = 1000000")
-	dbMustExecute(db, "PRAGMA temp_store = MEMORY")
-	dbMustExecute(db, "PRAGMA synchronous = OFF")
-	dbMustExecute(db, "PRAGMA journal_mode = WAL")
-	dbMustExecute(db, "PRAGMA busy_timeout = 500000")
-	dbMustExecute(db, "CREATE TABLE IF NOT EXISTS image_urls (page_url TEXT PRIMARY KEY UNIQUE, image_url TEXT, last_modified TEXT, filename TEXT)")
-	dbMustExecute(db, "CREATE INDEX IF NOT EXISTS page_urls ON image_urls(page_url)")
-	dbMustExecute(db, "PRAGMA optimize")
-	dbMustExecute(db, "PRAGMA vacuum")
-	defer dbpool.Put(db)
-	fmt.Printf("\n")
-
-	imagePages := map[string]*string{}
-
-	sort.Sort(sortorder.Natural(artists))
-	for i, artist := range artists {
-		fmt.Printf("Scanning artist %s (#%d of %d) for links...\n", artist, i+1, len(artists))
-		pageTypes := []string{}
-		if !opts.NoGrabGallery {
-			pageTypes = append(pageTypes, "gallery")
-		}
-		if opts.GrabFavourites {
-			pageTypes = append(pageTypes, "favorites")
-		}
-		if opts.GrabScraps {
-			pageTypes = append(pageTypes, "scraps")
-		}
 
-		for _, pageType := range pageTypes {
-			counter := 0
-			for {
-				counter++
-				galleryPage := fmt.Sprintf("https://www.furaffinity.net/%s/%s/%d/", pageType, artist, counter)
-				fmt.Printf("Going to %s's %s page #%d...", artist, pageType, counter)
-				err := openURL(galleryPage)
-				if err != nil {
-					fmt.Printf("Got error while getting %s: %v\n", galleryPage, err)
-					continue
-				}
-
-				fmt.Printf(".")
-				newImagePages := map[*url.URL]*string{}
-				for _, link := range bow.Links() {
-					if strings.Contains(link.URL.Path, "/view/") {
-						newImagePages[link.URL] = &artist
-					}
-				}
-				newImageCount := 0
-				for k, v := range newImagePages {
-					// if already downloaded, don't add it
-					isDownloaded, _ := dbCheckIfDownloaded(db, k)
-					if !isDownloaded {
-						_, ok := imagePages[k.String()]
-						if !ok {
-							imagePages[k.String()] = v
-							newImageCount++
-						}
-					}
-				}
-				fmt.Printf(" Got %d valid and %d new images\n", len(newImagePages), newImageCount)
-				if !opts.NoFastScan && newImageCount == 0 {
-					break
-				}
-				if len(newImagePages) == 0 {
-					break
-				}
-			}
-		}
+	// parse command line options, command and its arguments don't have to be
+	// there when asking for help
+	if early.Help || len(os.Args) == 1 {
+		parser.Options &^= flags.PrintErrors
+		parser.SubcommandsOptional = true
+	}
+	_, err = parser.Parse()
+	if err != nil && !early.Help {
+		return exitUsage
 	}
 
-	// sort
-	keys := make([]string, 0, len(imagePages))
-	for key := range imagePages {
-		keys = append(keys, key)
+	// we do this to avoid having separate 'Help Options' section in help
+	// screen. Help that wasn't asked for means command line was wrong.
+	if early.Help {
+		parser.WriteHelp(os.Stdout)
+		return exitOK
+	}
+	if parser.Active == nil {
+		parser.WriteHelp(os.Stdout)
+		return exitUsage
 	}
 
-	sort.Sort(sortorder.Natural(keys))
+	_, err = fa.ParsePathTemplate(opts.PathTemplate)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return exitUsage
+	}
 
-	fmt.Printf("Will get total %d pictures\n", len(keys))
+	command := parser.Active.Name
+	subcommand := ""
+	if parser.Active.Active != nil {
+		subcommand = parser.Active.Active.Name
+	}
 
-	var wg sync.WaitGroup
-	for counter, imagePage := range keys {
-		length := len(keys) - 1
-		URL, err := url.Parse(imagePage)
-		if err != nil {
-			fmt.Printf("Got error while parsing URL %s: %v\n", imagePage, err)
-			continue
-		}
-		artist := imagePages[imagePage]
-		fmt.Printf("[#%6d of %6d] Queuing %s\n", counter, length, URL.Path)
-		// check if it's in db and skip if it is
-		isDownloaded, err := dbCheckIfDownloaded(db, URL)
-		if err != nil {
-			fmt.Printf("[#%6d of %6d] Failed querying database, will download anyway: %s\n", counter, length, err)
-		}
-		if isDownloaded {
-			fmt.Printf("[#%6d of %6d] Skipped (already in database)\n", counter, length)
-			continue
-		}
-		err = openURL(imagePage)
+	var scan *scanOptions
+	switch command {
+	case "gallery":
+		scan = &opts.Gallery.scanOptions
+	case "sync":
+		scan = &opts.Sync.scanOptions
+	case "retry-failed":
+		scan = &opts.RetryFailed.scanOptions
+	}
+	var since time.Time
+	if scan != nil && scan.Since != "" {
+		since, err = fa.ParseSince(scan.Since)
 		if err != nil {
-			fmt.Printf("[#%6d of %6d] Got error while getting %s: %v\n", counter, length, imagePage, err)
-			continue
+			fmt.Printf("Bad --since: %v\n", err)
+			return exitUsage
 		}
-
-		var image *url.URL
-
-		for _, link := range bow.Links() {
-			if link.Text == "Download" {
-				image = link.URL
-			}
-		}
-
-		if image == nil {
-			fmt.Printf("[#%6d of %6d] Page %s does not have image link -- skipping (page title is %s)\n", counter, length, imagePage, bow.Title())
-			continue
-		}
-
-		wg.Add(1)
-		go func(image url.URL, artist *string, dbpool *sqlitex.Pool, URL url.URL, counter int, length int, wg *sync.WaitGroup) {
-			defer wg.Done()
-			filename := path.Base(image.Path)
-
-			// if it's "1234567890." (sometimes it happens), then append artist name
-			if m := brokenFilename.FindString(filename); len(m) != 0 {
-				filename = filename + *artist + ".unnamedimage.jpg"
-			}
-
-			filepath := path.Join(opts.DownloadDirectory, filename)
-
-			// create download directory if needed
-			err := os.MkdirAll(opts.DownloadDirectory, 0700)
-			if err != nil {
-				fmt.Printf("[#%6d of %6d] Couldn't create download directory %s: %s\n", counter, length, opts.DownloadDirectory, err)
-				return
-			}
-
-			// smaller scope so that we can close the file right after we're done with it
-			var lastModified time.Time
-			var contentLength int64
-			// get image's size
-			{
-				resp, err := http.Head(image.String())
-				if err != nil {
-					fmt.Printf("[#%6d of %6d] Failed to HEAD on URL '%s': %s\n", counter, length, image.String(), err)
-					return
-				}
-				contentLength = resp.ContentLength
-				if resp.Body != nil {
-					resp.Body.Close()
-				}
-			}
-
-			// check if file exists and filesize matches
-			var stat os.FileInfo
-			if stat, err = os.Stat(filepath); err == nil {
-				if int64(contentLength) == stat.Size() {
-					// skip, file exists and size matches
-					lastModified = setimagetime(filepath)
-					fmt.Printf("[#%6d of %6d] Skipped %s (already exists and filesize matches)\n", counter, length, filename)
-					// save to database
-					err = dbSetImageURL(dbpool, URL, image, lastModified, filename)
-					if err != nil {
-						fmt.Printf("[#%6d of %6d] Failed updating database: %s\n", counter, length, err)
-						return
-					}
-					return
-				}
-			}
-
-			// fetch the image
-			resp, err := http.Get(image.String())
-			if resp != nil { // even if err != nil, resp can be not nil as well
-				defer resp.Body.Close()
-			}
-			if err != nil {
-				fmt.Printf("[#%6d of %6d] Failed to get URL '%s': %s\n", counter, length, image.String(), err)
-				return
-			}
-
-			// create temporary download file
-			out, err := os.Create(filepath + ".download")
-			if err != nil {
-				fmt.Printf("[#%6d of %6d] Failed to create file '%s': %s\n", counter, length, filepath, err)
-				return
-			}
-			defer out.Close()
-
-			// save the image
-			// err = resp.BodyWriteTo(out)
-			written, err := io.Copy(out, resp.Body)
-			if err != nil {
-				fmt.Printf("[#%6d of %6d] Failed to download URL '%s': %s\n", counter, length, image.String(), err)
-				return
-			}
-
-			if written != contentLength {
-				fmt.Printf("[#%6d of %6d] Content length of %v != %v written, not marking as done\n", counter, length, contentLength, written)
-				return
-			}
-
-			// get last-modified
-			lastmod := resp.Header.Get("Last-Modified")
-			if len(lastmod) != 0 {
-				lastModified, err = time.Parse(time.RFC1123, lastmod)
-				if err != nil {
-					fmt.Printf("[#%6d of %6d] Failed to parse lastModified from %s, ignoring lastmodified: %s\n", counter, length, string(lastmod), err)
-					return
-				}
-			}
-
-			// rename temporary file to proper name
-			err = os.Rename(filepath+".download", filepath)
-			if err != nil {
-				fmt.Printf("[#%6d of %6d] Failed to rename %s to %s: %s\n", counter, length, filename+".download", filename, err)
-				return
-			}
-
-			// set file's time
-			setimagetime(filepath)
-
-			// save to database
-			err = dbSetImageURL(dbpool, URL, image, lastModified, filename)
-			if err != nil {
-				fmt.Printf("[#%6d of %6d] Failed updating database: %s\n", counter, length, err)
-				return
-			}
-			fmt.Printf("[#%6d of %6d] Saved %s (%v bytes)\n", counter, length, filename, contentLength)
-		}(*image, artist, dbpool, *URL, counter, length, &wg)
 	}
-	wg.Wait()
-}
-
-// ----------------
-// helper functions
-// ----------------
-
-// check if it's in db and skip if it is
-func dbCheckIfDownloaded(db *sqlite.Conn, URL *url.URL) (bool, error) {
-	dbkey := URL.Path
-	var filename string
-	fn := func(stmt *sqlite.Stmt) error {
-		filename = stmt.ColumnText(0)
-		return nil
+	// exports may be writing to stdout, keep it clean
+	quiet := command == "export" || subcommand == "export"
+	downloads := command == "gallery" || command == "sync" || command == "retry-failed" || command == "watchlist"
+	if downloads && !opts.NoProgress && isTerminal(os.Stdout) {
+		display = newProgressDisplay(os.Stdout)
+		defer display.close()
 	}
-	err := sqlitex.Exec(db, "SELECT filename FROM image_urls WHERE page_url = ? LIMIT 1", fn, dbkey)
+	closeLog, err := setupLog(quiet)
 	if err != nil {
-		return false, err
-	} else if filename != "" {
-		return true, nil
+		display.close()
+		fmt.Printf("%v\n", err)
+		return exitUsage
 	}
-	return false, nil
-}
+	defer closeLog()
 
-func dbMustExecute(db *sqlite.Conn, pragma string) {
-	err := sqlitex.ExecTransient(db, pragma, nil)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to execute statement %s: %s", pragma, err))
+	if command == "db" && subcommand == "migrate" && opts.DB.Migrate.DryRun {
+		return exitCode(printPendingMigrations())
 	}
-}
 
-func dbSetImageURL(dbpool *sqlitex.Pool, URL url.URL, image url.URL, lastModified time.Time, filename string) error {
-	db := dbpool.Get(nil)
-	if db == nil {
-		return fmt.Errorf("Couldn't get db from dbpool")
-	}
-	defer dbpool.Put(db)
-	dbkey := URL.Path
-	stmt, err := db.Prepare("INSERT OR REPLACE INTO image_urls (page_url, image_url, last_modified, filename) VALUES ($page_url, $image_url, $last_modified, $filename)")
-	if err != nil {
-		fmt.Printf("Couldn't prepare SQL query for setting image url: %s\n", err)
-		return err
-	}
-	stmt.SetText("$page_url", dbkey)
-	stmt.SetText("$image_url", image.String())
-	stmt.SetText("$last_modified", lastModified.String())
-	stmt.SetText("$filename", filename)
-	for {
-		if hasRow, err := stmt.Step(); err != nil {
-			fmt.Printf("Couldn't execute SQL query for setting image url: %s\n", err)
-			return err
-		} else if !hasRow {
-			break
+	// everyone from config file, checked before anything is opened
+	var artists []string
+	if command == "sync" {
+		for _, profile := range config.Artists {
+			artists = append(artists, profile.Name)
+		}
+		if len(artists) == 0 {
+			fa.Log.Error("No artists in config file, nothing to sync", fa.Fields{"file": path.Join(opts.ConfigDir, fa.ConfigFilename)})
+			return exitUsage
 		}
 	}
-	return nil
-}
-func setimagetime(filepath string) time.Time {
-	var t time.Time
-	filename := path.Base(filepath)
-	m := firstTenDigits.FindString(filename)
-	if len(m) == 0 {
-		return t
-	}
-	value, err := strconv.ParseInt(m, 10, 64)
-	if err != nil {
-		fmt.Printf("Couldn't parse %v into uint, skipping: %v\n", m, err)
-		return t
-	}
-	t = time.Unix(value, 0)
-	if t.Year() < 2000 {
-		fmt.Printf("Skipping %v (%v) for %s because year was less than 2000", value, t, filename)
-		return t
+
+	// first signal stops queueing new downloads, second one aborts those
+	// in progress
+	stop, abort := fa.NotifyShutdown()
+
+	fa.Log.Info("Opening cookie jar and database", fa.Fields{"config_dir": opts.ConfigDir})
+	options := fa.Options{
+		ConfigDir:         opts.ConfigDir,
+		DownloadDirectory: opts.DownloadDirectory,
+		Sidecar:           opts.Sidecar,
+		EmbedMetadata:     opts.EmbedMetadata,
+		CDNRate:           opts.CDNRate,
+		PathTemplate:      opts.PathTemplate,
 	}
-	if time.Now().Before(t) {
-		fmt.Printf("Skipping %v (%v) for %s because time is in the future", value, t, filename)
-		return t
+	if display != nil {
+		options.Progress = display
 	}
-	err = os.Chtimes(filepath, t, t)
+	client, err := fa.NewClient(abort, options)
 	if err != nil {
-		fmt.Printf("Couldn't change file %s time: %v\n", filepath, err)
-		return t
+		fa.Log.Error("Failed to open cookie jar and database", fa.Fields{"error": err})
+		return exitError
 	}
-	return t
-}
-
-func updateDefaults(parser *flags.Parser) {
-	// expand ~ into home directory
-	expandDefaultDownloadDirectory(parser)
-	// set config directory to platform standard
-	setDefaultConfigDirectory(parser)
+	defer client.Close()
+
+	switch command {
+	case "gallery":
+		err = gallery(stop, abort, client, config, scan, since, opts.Gallery.Args.Artists)
+	case "sync":
+		err = gallery(stop, abort, client, config, scan, since, artists)
+	case "retry-failed":
+		// skips scanning and only queues previous failures
+		err = gallery(stop, abort, client, config, scan, since, nil)
+	case "watchlist":
+		err = watchlist(stop, abort, client)
+	case "db":
+		switch subcommand {
+		case "migrate":
+			// NewClient has already done it
+			fmt.Printf("Database is up to date\n")
+		case "stats":
+			err = dbStats(client)
+		case "failures":
+			err = dbFailures(client)
+		case "forget":
+			err = dbForget(client, opts.DB.Forget.Args.Pages)
+		case "sources":
+			err = dbSources(client, opts.DB.Sources.Args.Artist, opts.DB.Sources.PageType)
+		case "artists":
+			err = dbArtists(client)
+		}
+	case "verify":
+		err = verify(client)
+	case "export":
+		err = export(client)
+	case "reorganize":
+		err = reorganize(stop, client)
+	case "login":
+		err = login(stop, client)
+	case "cookies":
+		switch subcommand {
+		case "import":
+			err = cookiesImport(stop, client)
+		case "export":
+			err = cookiesExport(client)
+		}
+	}
+	return exitCode(err)
 }
 
-func expandDefaultDownloadDirectory(parser *flags.Parser) {
-	option := parser.Command.FindOptionByLongName("download-directory")
-	if option == nil {
-		panic("SHOULD NOT HAPPEN: option is nil")
-	}
-	path := option.Default[0]
-	newpath, err := homedir.Expand(path)
+// setupLog replaces default logger with one configured by command line
+// options. Without log file, log goes to standard output, above progress
+// if it's shown, or to standard error when quiet is set.
+func setupLog(quiet bool) (func(), error) {
+	level, err := fa.ParseLevel(opts.LogLevel)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	option.Default[0] = newpath
-	option.DefaultMask = path
-}
-
-func setDefaultConfigDirectory(parser *flags.Parser) {
-	option := parser.Command.FindOptionByLongName("config-directory")
-	if option == nil {
-		panic("SHOULD NOT HAPPEN: option is nil")
+	var out io.Writer = os.Stdout
+	if quiet {
+		out = os.Stderr
 	}
-	configpath := configdir.LocalConfig("FA Downloader")
-	option.Default = []string{configpath}
-
-	// replace full path to home directory with ~
-	home, err := homedir.Dir()
-	if err != nil {
-		panic(err)
+	if display != nil {
+		out = display
 	}
-	if strings.HasPrefix(configpath, home) {
-		option.DefaultMask = strings.Replace(configpath, home, "~", 1)
+	closeLog := func() {}
+	if opts.LogFile != "" {
+		file, err := os.OpenFile(opts.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open log file %s: %w", opts.LogFile, err)
+		}
+		out = file
+		closeLog = func() { file.Close() }
 	}
+	fa.Log = fa.NewLogger(out, level, opts.LogFormat)
+	return closeLog, nil
 }
 
-var last = time.Now()
 var pprofListener net.Listener
 
 func setupPprof() error {
@@ -1,18 +0,0 @@
-module github.com/afurry/fadownloader/fawadownloader
-
-go 1.14
-
-require (
-	crawshaw.io/sqlite v0.3.2
-	github.com/PuerkitoBio/goquery v1.5.1 // indirect
-	github.com/headzoo/surf v1.0.1-0.20180909134844-a4a8c16c01dc
-	github.com/jessevdk/go-flags v1.4.0
-	github.com/juju/go4 v0.0.0-20160222163258-40d72ab9641a // indirect
-	github.com/juju/persistent-cookiejar v0.0.0-20171026135701-d5e5a8405ef9
-	github.com/kirsle/configdir v0.0.0-20170128060238-e45d2f54772f
-	github.com/mitchellh/go-homedir v1.1.0
-	go.uber.org/ratelimit v0.1.0
-	golang.org/x/net v0.0.0-20200707034311-ab3426394381
-	gopkg.in/errgo.v1 v1.0.1 // indirect
-	gopkg.in/retry.v1 v1.0.3 // indirect
-)
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/afurry/fadownloader/fa"
+	"github.com/fvbommel/sortorder"
+)
+
+// scanOptions are shared by commands that scan galleries and download images
+type scanOptions struct {
+	NoFastScan     bool   `long:"no-fast-scan" description:"Disable fast scanning for artist's images"`
+	NoGrabGallery  bool   `short:"g" long:"no-grab-gallery" description:"Don't grab artist's gallery"`
+	GrabFavourites bool   `short:"f" long:"grab-favourites" description:"Grab artist's favourites"`
+	GrabScraps     bool   `short:"s" long:"grab-scraps" description:"Grab artist's scraps"`
+	GrabFolders    bool   `long:"grab-folders" description:"Scan every folder of artist's gallery, recording which folders submissions are in"`
+	Workers        int    `short:"w" long:"workers" description:"Number of images to download in parallel" value-name:"N" default:"4"`
+	Since          string `long:"since" description:"Only download images posted on or after this date" value-name:"YYYY-MM-DD"`
+}
+
+// downloadJob is a single submission queued for one of the download workers
+type downloadJob struct {
+	client *fa.Client
+	sub    *fa.Submission
+	// scan found the submission, nil for previous failures
+	scan *scanState
+	// fields describe the submission in log events
+	fields fa.Fields
+}
+
+// scanState is how scanning artist's gallery, scraps or favorites goes
+// during this run. Once every page was scanned and every image found was
+// downloaded, the scan is recorded as completed and fast scan can trust it
+// next time.
+type scanState struct {
+	artist   string
+	pageType string
+	// folder is set when scanning a folder of artist's gallery
+	folder *fa.GalleryFolder
+	// complete is set once every page that needed scanning was
+	complete  bool
+	highestID int64
+	// failed counts images that failed, updated by download workers
+	failed int32
+}
+
+// key is what scan is recorded as in database: its page type, or folder ID
+// for folders
+func (s *scanState) key() string {
+	if s.folder != nil {
+		return fmt.Sprintf("folder/%d", s.folder.ID)
+	}
+	return s.pageType
+}
+
+// fields describe scan in log events
+func (s *scanState) fields() fa.Fields {
+	fields := fa.Fields{"artist": s.artist, "page_type": s.pageType}
+	if s.folder != nil {
+		fields["folder"] = s.folder.Name
+	}
+	return fields
+}
+
+// runSummary counts what happened during a run. Counters are updated by
+// download workers, so use atomic operations on them.
+type runSummary struct {
+	scanned    int64
+	queued     int64
+	downloaded int64
+	skipped    int64
+	failed     int64
+	// givenUp counts images that failed for good, like deleted ones, they
+	// aren't retried and don't make the run fail
+	givenUp int64
+	// rateLimited is set when something failed because FA kept answering
+	// 429
+	rateLimited int32
+}
+
+func (s *runSummary) fail(err error) {
+	if fa.GivenUp(err) {
+		atomic.AddInt64(&s.givenUp, 1)
+		return
+	}
+	atomic.AddInt64(&s.failed, 1)
+	if errors.Is(err, fa.ErrRateLimited) {
+		atomic.StoreInt32(&s.rateLimited, 1)
+	}
+}
+
+func (s *runSummary) print() {
+	fa.Log.Info("Finished", fa.Fields{
+		"scanned":    atomic.LoadInt64(&s.scanned),
+		"queued":     atomic.LoadInt64(&s.queued),
+		"downloaded": atomic.LoadInt64(&s.downloaded),
+		"skipped":    atomic.LoadInt64(&s.skipped),
+		"failed":     atomic.LoadInt64(&s.failed),
+		"given_up":   atomic.LoadInt64(&s.givenUp),
+	})
+}
+
+// err is fa.ErrPartialFailure (or fa.ErrRateLimited, if that's what it was)
+// when anything failed
+func (s *runSummary) err() error {
+	failed := atomic.LoadInt64(&s.failed)
+	if failed == 0 {
+		return nil
+	}
+	if atomic.LoadInt32(&s.rateLimited) != 0 {
+		return fmt.Errorf("%d images failed: %w", failed, fa.ErrRateLimited)
+	}
+	return fmt.Errorf("%d images failed: %w", failed, fa.ErrPartialFailure)
+}
+
+// artistSettings are options used for a single artist: command line options
+// (with their defaults from config file) overridden by artist's profile
+type artistSettings struct {
+	name      string
+	fastScan  bool
+	pageTypes []string
+	folders   bool
+	since     time.Time
+	client    *fa.Client
+}
+
+// gallery scans artists' galleries for new images and downloads them,
+// together with what failed on previous runs. Pages FA wouldn't show (like
+// when session has expired) are returned as *fa.PageError: right away if
+// nothing else is going to load either, once everything else is done
+// otherwise. Images that failed make it return fa.ErrPartialFailure.
+func gallery(stop, abort context.Context, client *fa.Client, config *fa.Config, scan *scanOptions, since time.Time, artists []string) error {
+	var firstPageErr error
+	summary := &runSummary{}
+	display.track(summary)
+
+	imagePages := map[string]*artistSettings{}
+	// where each image was found
+	scans := map[string]*scanState{}
+	states := []*scanState{}
+
+	sort.Sort(sortorder.Natural(artists))
+artists:
+	for i, artist := range artists {
+		if stop.Err() != nil {
+			break
+		}
+		settings, err := newArtistSettings(scan, artist, config.Profile(artist), client, since)
+		if err != nil {
+			fa.Log.Error("Skipping artist", fa.Fields{"artist": artist, "error": err})
+			continue
+		}
+		fa.Log.Info("Scanning artist", fa.Fields{"artist": artist, "position": i + 1, "total": len(artists)})
+
+		artistScans := []*scanState{}
+		for _, pageType := range settings.pageTypes {
+			artistScans = append(artistScans, &scanState{artist: artist, pageType: pageType})
+		}
+		if settings.folders {
+			display.scanning("Listing %s's gallery folders (artist %d of %d)", artist, i+1, len(artists))
+			folders, err := client.GalleryFolders(stop, artist)
+			var pageErr *fa.PageError
+			if errors.As(err, &pageErr) && pageErr.Fatal() {
+				fa.Log.Error("Failed to list gallery folders, stopping", fa.Fields{"artist": artist, "error": err})
+				summary.print()
+				return err
+			}
+			if err != nil {
+				fa.Log.Error("Failed to list gallery folders, skipping them", fa.Fields{"artist": artist, "error": err})
+				if firstPageErr == nil && (pageErr != nil || errors.Is(err, fa.ErrRateLimited)) {
+					firstPageErr = err
+				}
+				if pageErr != nil && (pageErr.Class == fa.PageArtistNotFound || pageErr.Class == fa.PageAccountDisabled) {
+					continue artists
+				}
+			} else {
+				fa.Log.Info("Listed gallery folders", fa.Fields{"artist": artist, "folders": len(folders)})
+			}
+			for _, folder := range folders {
+				artistScans = append(artistScans, &scanState{artist: artist, pageType: "gallery", folder: folder})
+			}
+		}
+
+		for _, state := range artistScans {
+			states = append(states, state)
+			fastScan := settings.fastScan
+			if fastScan {
+				// stopping at first page without new images is only safe if
+				// everything before it was downloaded
+				previous, err := client.Store.ScanState(artist, state.key())
+				if err != nil {
+					fa.Log.Warn("Failed to get previous scan from database", state.fields().With(fa.Fields{"error": err}))
+				}
+				if previous == nil || !previous.Completed {
+					fa.Log.Info("Previous scan didn't complete, scanning all pages", state.fields())
+					fastScan = false
+				}
+			}
+			err = client.Store.StartScan(artist, state.key())
+			if err != nil {
+				fa.Log.Warn("Failed to record scan start in database", state.fields().With(fa.Fields{"error": err}))
+			}
+
+			counter := 0
+			for stop.Err() == nil {
+				counter++
+				fields := state.fields().With(fa.Fields{"page_number": counter})
+				var newImagePages []*url.URL
+				if state.folder != nil {
+					display.scanning("Scanning %s's folder %s page #%d (artist %d of %d)", artist, state.folder.Name, counter, i+1, len(artists))
+					newImagePages, err = client.FolderPages(stop, state.folder, counter)
+				} else {
+					display.scanning("Scanning %s's %s page #%d (artist %d of %d)", artist, state.pageType, counter, i+1, len(artists))
+					newImagePages, err = client.GalleryPages(stop, artist, state.pageType, counter)
+				}
+				var pageErr *fa.PageError
+				if errors.As(err, &pageErr) && pageErr.Fatal() {
+					fa.Log.Error("Failed to load gallery page, stopping", fields.With(fa.Fields{"error": err}))
+					summary.print()
+					return err
+				}
+				if err != nil {
+					// retries are already exhausted, skipping this page would
+					// silently lose its images, so stop scanning instead
+					fa.Log.Error("Failed to load gallery page, giving up on page type", fields.With(fa.Fields{"error": err}))
+					if firstPageErr == nil && (pageErr != nil || errors.Is(err, fa.ErrRateLimited)) {
+						firstPageErr = err
+					}
+					if pageErr == nil {
+						break
+					}
+					// other page types of missing artist won't be there either
+					if pageErr.Class == fa.PageArtistNotFound || pageErr.Class == fa.PageAccountDisabled {
+						continue artists
+					}
+					break
+				}
+
+				atomic.AddInt64(&summary.scanned, 1)
+				err = client.Store.RecordSources(newImagePages, artist, state.pageType)
+				if err != nil {
+					fa.Log.Warn("Failed to record where images were found", fields.With(fa.Fields{"error": err}))
+				}
+				// folders are mostly of images gallery scan finds as well, so
+				// fast scan goes on while there are images not known to be in
+				// the folder
+				newMembers := 0
+				if state.folder != nil {
+					newMembers, err = client.Store.RecordFolder(newImagePages, state.folder.Folder)
+					if err != nil {
+						fa.Log.Warn("Failed to record folder of images", fields.With(fa.Fields{"error": err}))
+					}
+					fields["new_in_folder"] = newMembers
+				}
+				newImageCount := 0
+				for _, page := range newImagePages {
+					if id, ok := fa.SubmissionID(page); ok && id > state.highestID {
+						state.highestID = id
+					}
+					// if already downloaded, don't add it
+					isDownloaded, _ := client.IsDownloaded(page)
+					if !isDownloaded && postedBefore(client, page, settings.since) {
+						// skipped on a previous run, not new
+						continue
+					}
+					if !isDownloaded {
+						_, ok := imagePages[page.String()]
+						if !ok {
+							imagePages[page.String()] = settings
+							scans[page.String()] = state
+							newImageCount++
+						}
+					}
+				}
+				fa.Log.Info("Scanned gallery page", fields.With(fa.Fields{"images": len(newImagePages), "new_images": newImageCount}))
+				if fastScan && newImageCount == 0 && newMembers == 0 {
+					state.complete = true
+					break
+				}
+				if len(newImagePages) == 0 {
+					state.complete = true
+					break
+				}
+			}
+		}
+	}
+
+	// retry what failed on previous runs, gallery pages are scanned again
+	// anyway
+	failures, err := client.Store.Failures()
+	if err != nil {
+		fa.Log.Error("Failed to get previous failures from database", fa.Fields{"error": err})
+	}
+	retrying := 0
+	// images whose download link we already know don't need their page reopened
+	knownImages := map[string]*url.URL{}
+	for _, failure := range failures {
+		if failure.GivenUp || failure.Stage == fa.StageGallery {
+			continue
+		}
+		retrying++
+		if _, ok := imagePages[failure.PageURL.String()]; !ok {
+			imagePages[failure.PageURL.String()] = nil
+		}
+		if failure.Stage != fa.StagePage && failure.ImageURL != nil {
+			knownImages[failure.PageURL.String()] = failure.ImageURL
+		}
+	}
+	if retrying > 0 {
+		fa.Log.Info("Retrying images that failed on previous runs", fa.Fields{"images": retrying})
+	}
+
+	// sort
+	keys := make([]string, 0, len(imagePages))
+	for key := range imagePages {
+		keys = append(keys, key)
+	}
+
+	sort.Sort(sortorder.Natural(keys))
+
+	fa.Log.Info("Queuing images", fa.Fields{"images": len(keys)})
+	display.scanning("Downloading %d images", len(keys))
+
+	if scan.Workers < 1 {
+		scan.Workers = 1
+	}
+	jobs := make(chan downloadJob, scan.Workers)
+	var wg sync.WaitGroup
+	for worker := 1; worker <= scan.Workers; worker++ {
+		wg.Add(1)
+		go downloadWorker(stop, abort, worker, jobs, summary, &wg)
+	}
+
+	var fatalErr error
+queue:
+	for counter, imagePage := range keys {
+		if stop.Err() != nil {
+			break
+		}
+		length := len(keys) - 1
+		URL, err := url.Parse(imagePage)
+		if err != nil {
+			fa.Log.Error("Failed to parse URL", fa.Fields{"url": imagePage, "error": err})
+			continue
+		}
+		fields := fa.PageFields(URL).With(fa.Fields{"position": counter, "total": length})
+		if settings := imagePages[imagePage]; settings != nil {
+			fields["artist"] = settings.name
+		}
+		fa.Log.Debug("Checking submission", fields)
+		// check if it's in db and skip if it is
+		isDownloaded, err := client.IsDownloaded(URL)
+		if err != nil {
+			fa.Log.Warn("Failed querying database, will download anyway", fields.With(fa.Fields{"error": err}))
+		}
+		if isDownloaded {
+			fa.Log.Info("Skipped, already in database", fields)
+			client.Store.ClearFailure(URL)
+			atomic.AddInt64(&summary.skipped, 1)
+			continue
+		}
+		sub := &fa.Submission{PageURL: URL, ImageURL: knownImages[imagePage]}
+		if sub.ImageURL == nil {
+			sub, err = client.Submission(stop, URL)
+			var pageErr *fa.PageError
+			if errors.As(err, &pageErr) && pageErr.Fatal() {
+				fa.Log.Error("Failed to load submission, stopping", fields.With(fa.Fields{"error": err}))
+				fatalErr = err
+				break
+			}
+			if err != nil && stop.Err() != nil {
+				// interrupted while loading, not the submission's fault
+				break
+			}
+			if err != nil {
+				fa.Log.Error("Failed to load submission, skipping", fields.With(fa.Fields{"error": err}))
+				summary.fail(err)
+				if scan := scans[imagePage]; scan != nil && !fa.GivenUp(err) {
+					atomic.AddInt32(&scan.failed, 1)
+				}
+				continue
+			}
+		}
+		// previous failures weren't scanned, but their artist may have a
+		// profile as well, found by username from the page or, when the
+		// page wasn't reopened, from the image URL
+		settings := imagePages[imagePage]
+		if settings == nil {
+			artist := sub.ArtistUsername()
+			settings, err = newArtistSettings(scan, artist, config.Profile(artist), client, since)
+			if err != nil {
+				fa.Log.Error("Skipping submission", fields.With(fa.Fields{"error": err}))
+				continue
+			}
+		}
+		if !settings.since.IsZero() && sub.ImageURL != nil {
+			if posted := sub.Posted(); !posted.IsZero() && posted.Before(settings.since) {
+				fa.Log.Info("Skipped, posted before since", fields.With(fa.Fields{"posted": posted, "since": settings.since}))
+				atomic.AddInt64(&summary.skipped, 1)
+				continue
+			}
+		}
+		// name broken filenames after the artist we were scanning, unless
+		// the page told us who the artist is
+		if settings.name != "" && sub.Artist == "" {
+			sub.Artist = settings.name
+		}
+		scan := scans[imagePage]
+		if scan != nil {
+			sub.PageType = scan.pageType
+		}
+		fields["artist"] = sub.Artist
+
+		select {
+		case jobs <- downloadJob{client: settings.client, sub: sub, scan: scan, fields: fields}:
+			fa.Log.Debug("Queued", fields)
+			atomic.AddInt64(&summary.queued, 1)
+		case <-stop.Done():
+			break queue
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	summary.print()
+	if fatalErr != nil {
+		return fatalErr
+	}
+	if stop.Err() != nil {
+		fa.Log.Warn("Stopped, run again to get the rest", nil)
+	} else {
+		for _, state := range states {
+			if !state.complete || atomic.LoadInt32(&state.failed) > 0 {
+				continue
+			}
+			err = client.Store.FinishScan(state.artist, state.key(), state.highestID)
+			if err != nil {
+				fa.Log.Warn("Failed to record completed scan in database", state.fields().With(fa.Fields{"error": err}))
+			}
+		}
+	}
+	if firstPageErr != nil {
+		return firstPageErr
+	}
+	return summary.err()
+}
+
+// newArtistSettings applies artist's profile, if there's one, on top of
+// command line options
+func newArtistSettings(scan *scanOptions, artist string, profile *fa.ArtistProfile, client *fa.Client, since time.Time) (*artistSettings, error) {
+	noFastScan := scan.NoFastScan
+	noGrabGallery := scan.NoGrabGallery
+	grabFavourites := scan.GrabFavourites
+	grabScraps := scan.GrabScraps
+	grabFolders := scan.GrabFolders
+	settings := &artistSettings{name: artist, since: since, client: client}
+
+	if profile != nil {
+		override(&noFastScan, profile.NoFastScan)
+		override(&noGrabGallery, profile.NoGrabGallery)
+		override(&grabFavourites, profile.GrabFavourites)
+		override(&grabScraps, profile.GrabScraps)
+		override(&grabFolders, profile.GrabFolders)
+		if profile.DownloadDirectory != "" {
+			settings.client = client.WithDownloadDirectory(profile.DownloadDirectory)
+		}
+		if profile.Since != "" {
+			var err error
+			settings.since, err = fa.ParseSince(profile.Since)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	settings.fastScan = !noFastScan
+	settings.folders = grabFolders
+	if !noGrabGallery {
+		settings.pageTypes = append(settings.pageTypes, "gallery")
+	}
+	if grabFavourites {
+		settings.pageTypes = append(settings.pageTypes, "favorites")
+	}
+	if grabScraps {
+		settings.pageTypes = append(settings.pageTypes, "scraps")
+	}
+	return settings, nil
+}
+func override(option *bool, value *bool) {
+	if value != nil {
+		*option = *value
+	}
+}
+
+// postedBefore checks if submission's stored metadata says it was posted
+// before since. Submissions whose metadata isn't stored yet aren't.
+func postedBefore(client *fa.Client, page *url.URL, since time.Time) bool {
+	if since.IsZero() {
+		return false
+	}
+	posted, err := client.Store.PostedAt(page)
+	if err != nil {
+		fa.Log.Warn("Failed to get posting date from database", fa.PageFields(page).With(fa.Fields{"error": err}))
+		return false
+	}
+	return !posted.IsZero() && posted.Before(since)
+}
+
+// downloadWorker downloads queued submissions until jobs channel is closed.
+// Once stop is done, jobs that haven't started yet are dropped, once abort is
+// done, downloads in progress are interrupted as well.
+func downloadWorker(stop, abort context.Context, worker int, jobs <-chan downloadJob, summary *runSummary, wg *sync.WaitGroup) {
+	defer wg.Done()
+	done := 0
+	for job := range jobs {
+		if stop.Err() != nil {
+			continue
+		}
+		done++
+		fields := job.fields.With(fa.Fields{"worker": worker, "job": done})
+		result, err := job.client.Download(abort, job.sub)
+		if err != nil {
+			fa.Log.Error("Failed to download image", fields.With(fa.Fields{"url": job.sub.ImageURL, "error": err}))
+			if abort.Err() == nil {
+				summary.fail(err)
+			}
+			if job.scan != nil && !fa.GivenUp(err) {
+				atomic.AddInt32(&job.scan.failed, 1)
+			}
+			continue
+		}
+		fields = fields.With(fa.Fields{"file": result.Filename, "bytes": result.Size})
+		if result.Skipped {
+			fa.Log.Info("Skipped, file already exists and size matches", fields)
+			atomic.AddInt64(&summary.skipped, 1)
+			continue
+		}
+		atomic.AddInt64(&summary.downloaded, 1)
+		if result.Resumed > 0 {
+			fields["resumed"] = result.Resumed
+		}
+		fa.Log.Info("Saved image", fields)
+	}
+}
@@ -4,7 +4,8 @@ go 1.15
 
 require (
 	crawshaw.io/sqlite v0.3.2
-	github.com/PuerkitoBio/goquery v1.6.0 // indirect
+	github.com/BurntSushi/toml v0.4.1
+	github.com/PuerkitoBio/goquery v1.6.0
 	github.com/fvbommel/sortorder v1.0.2
 	github.com/headzoo/surf v1.0.1-0.20180909134844-a4a8c16c01dc
 	github.com/jessevdk/go-flags v1.4.0
@@ -13,7 +14,7 @@ require (
 	github.com/kirsle/configdir v0.0.0-20170128060238-e45d2f54772f
 	github.com/mitchellh/go-homedir v1.1.0
 	go.uber.org/ratelimit v0.1.0
-	golang.org/x/net v0.0.0-20200923182212-328152dc79b1 // indirect
+	golang.org/x/net v0.0.0-20200923182212-328152dc79b1
 	gopkg.in/errgo.v1 v1.0.1 // indirect
 	gopkg.in/retry.v1 v1.0.3 // indirect
 )
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// login asks for username, password and captcha and logs in, saving the
+// session into the cookie jar. Interrupting it at a prompt gives up right
+// away.
+func login(ctx context.Context, client *fa.Client) error {
+	var err error
+	username := opts.Login.Username
+	if username == "" {
+		username, err = prompt(ctx, "Username")
+		if err != nil {
+			return err
+		}
+	}
+	password, err := promptPassword(ctx, "Password")
+	if err != nil {
+		return err
+	}
+
+	user, err := client.Login(ctx, username, password, func(captchaPath string) (string, error) {
+		fmt.Printf("Captcha saved to %s, open it and type what it says\n", captchaPath)
+		return prompt(ctx, "Captcha")
+	})
+	if err != nil {
+		fa.Log.Error("Failed to log in", fa.Fields{"error": err})
+		return err
+	}
+	fa.Log.Info("Logged in, session saved to cookie jar", fa.Fields{"user": user})
+	return nil
+}
+
+func prompt(ctx context.Context, label string) (string, error) {
+	fmt.Printf("%s: ", label)
+	line, err := readLine(ctx)
+	return strings.TrimSpace(line), err
+}
+
+// readLine reads a line from standard input, giving up once ctx is done.
+// Reading itself can't be interrupted, but nothing reads after giving up.
+func readLine(ctx context.Context) (string, error) {
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := stdin.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		return line, nil
+	case <-ctx.Done():
+		fmt.Printf("\n")
+		return "", fmt.Errorf("Login interrupted: %w", ctx.Err())
+	}
+}
+
+// promptPassword turns off echo while password is typed, where stty is
+// available. Echo is turned back on even when interrupted.
+func promptPassword(ctx context.Context, label string) (string, error) {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") == nil {
+		defer stty("echo")
+	}
+	fmt.Printf("%s: ", label)
+	line, err := readLine(ctx)
+	if err != nil {
+		return "", err
+	}
+	// typed newline wasn't echoed
+	fmt.Printf("\n")
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+// display shows live progress, it's nil when output isn't a terminal. Its
+// methods do nothing on nil, so callers don't need to check.
+var display *progressDisplay
+
+// progressDisplay draws what's being scanned, a bar for every download in
+// progress and totals at the bottom of the terminal. Log lines written
+// through it are printed above all that.
+type progressDisplay struct {
+	mu      sync.Mutex
+	out     *os.File
+	width   int
+	started time.Time
+	// drawn is how many lines are on screen now
+	drawn  int
+	closed bool
+	done   chan struct{}
+
+	status    string
+	summary   *runSummary
+	transfers map[*fa.Submission]*transfer
+	// received is bytes downloaded during this run
+	received int64
+	// sizes of images seen so far, to guess how long the rest will take
+	seenBytes int64
+	seenFiles int64
+	finished  int64
+}
+
+// transfer is a single download in progress
+type transfer struct {
+	name    string
+	size    int64
+	written int64
+	started time.Time
+}
+
+func newProgressDisplay(out *os.File) *progressDisplay {
+	d := &progressDisplay{
+		out:       out,
+		width:     terminalWidth(),
+		started:   time.Now(),
+		done:      make(chan struct{}),
+		transfers: map[*fa.Submission]*transfer{},
+	}
+	go d.run()
+	return d
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth asks stty, lines longer than terminal would wrap and mess
+// up redrawing
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err == nil {
+		fields := strings.Fields(string(out))
+		if len(fields) == 2 {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return 80
+}
+
+func (d *progressDisplay) run() {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			d.redraw(nil)
+			d.mu.Unlock()
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// close removes progress from the screen
+func (d *progressDisplay) close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	close(d.done)
+	d.redraw(nil)
+}
+
+// Write prints log lines above progress
+func (d *progressDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.redraw(p)
+	return len(p), nil
+}
+
+// scanning shows what's being scanned
+func (d *progressDisplay) scanning(format string, args ...interface{}) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = fmt.Sprintf(format, args...)
+}
+
+// track takes downloaded, skipped and failed counts from summary
+func (d *progressDisplay) track(summary *runSummary) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.summary = summary
+}
+
+func (d *progressDisplay) DownloadStarted(sub *fa.Submission, filename string, size, resumed int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.transfers[sub]
+	if !ok {
+		t = &transfer{started: time.Now()}
+		d.transfers[sub] = t
+		d.seenBytes += size
+		d.seenFiles++
+	}
+	t.name = filename
+	t.size = size
+	t.written = resumed
+}
+
+func (d *progressDisplay) Downloaded(sub *fa.Submission, n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.received += n
+	if t, ok := d.transfers[sub]; ok {
+		t.written += n
+	}
+}
+
+func (d *progressDisplay) DownloadFinished(sub *fa.Submission, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.transfers, sub)
+	d.finished++
+}
+
+// redraw replaces what's on screen with log lines, if any, followed by
+// fresh progress. It's written all at once so that it doesn't flicker.
+func (d *progressDisplay) redraw(log []byte) {
+	var b bytes.Buffer
+	if d.drawn > 0 {
+		// to the start of first progress line and clear everything below
+		fmt.Fprintf(&b, "\x1b[%dF\x1b[J", d.drawn)
+		d.drawn = 0
+	}
+	b.Write(log)
+	if !d.closed {
+		for _, line := range d.lines() {
+			b.WriteString(truncate(line, d.width-1))
+			b.WriteString("\n")
+			d.drawn++
+		}
+	}
+	d.out.Write(b.Bytes())
+}
+
+func (d *progressDisplay) lines() []string {
+	lines := []string{}
+	if d.status != "" {
+		lines = append(lines, d.status)
+	}
+
+	transfers := make([]*transfer, 0, len(d.transfers))
+	var remaining int64
+	for _, t := range d.transfers {
+		transfers = append(transfers, t)
+		if t.size > t.written {
+			remaining += t.size - t.written
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].started.Before(transfers[j].started) })
+	for _, t := range transfers {
+		lines = append(lines, transferLine(t, d.width-1))
+	}
+
+	elapsed := time.Since(d.started)
+	rate := float64(d.received) / elapsed.Seconds()
+	totals := fmt.Sprintf("%s downloaded, %s/s", formatBytes(d.received), formatBytes(int64(rate)))
+	if d.summary != nil {
+		queued := atomic.LoadInt64(&d.summary.queued)
+		// guess size of images that haven't started yet from those that have
+		pending := queued - d.finished - int64(len(d.transfers))
+		if pending > 0 && d.seenFiles > 0 {
+			remaining += pending * (d.seenBytes / d.seenFiles)
+		}
+		if rate > 0 && (remaining > 0 || pending > 0) {
+			eta := time.Duration(float64(remaining)/rate) * time.Second
+			totals += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+		}
+		totals += fmt.Sprintf(" | %d saved, %d skipped, %d failed, %d of %d queued done",
+			atomic.LoadInt64(&d.summary.downloaded), atomic.LoadInt64(&d.summary.skipped),
+			atomic.LoadInt64(&d.summary.failed), d.finished, queued)
+	}
+	return append(lines, totals)
+}
+
+// transferLine is a download's name, bar and how much of it is done
+func transferLine(t *transfer, width int) string {
+	done := fmt.Sprintf(" %s/%s", formatBytes(t.written), formatBytes(t.size))
+	percent := 0
+	if t.size > 0 {
+		percent = int(t.written * 100 / t.size)
+	}
+	bar := 20
+	filled := bar * percent / 100
+	if filled > bar {
+		filled = bar
+	}
+	line := fmt.Sprintf(" [%s%s] %3d%%%s", strings.Repeat("#", filled), strings.Repeat("-", bar-filled), percent, done)
+	name := truncate(t.name, width-len(line)-2)
+	return fmt.Sprintf("  %-*s%s", width-len(line)-2, name, line)
+}
+
+func truncate(s string, width int) string {
+	if width < 1 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width])
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+// reorganize moves downloaded images to where --path-template says they go,
+// finishing what an interrupted run left in the journal first
+func reorganize(ctx context.Context, client *fa.Client) error {
+	pending, err := client.Store.JournaledMoves()
+	if err != nil {
+		fa.Log.Error("Failed to get journaled moves", fa.Fields{"error": err})
+		return err
+	}
+	if opts.Reorganize.DryRun {
+		for _, move := range pending {
+			fa.Log.Info("Would finish interrupted move", fa.PageFields(move.PageURL).With(fa.Fields{"from": move.From, "to": move.To}))
+		}
+	} else if len(pending) > 0 {
+		fa.Log.Info("Finishing interrupted reorganize", fa.Fields{"moves": len(pending)})
+		_, err = client.ResumeReorganize(ctx)
+		if err != nil {
+			fa.Log.Error("Failed to finish interrupted reorganize", fa.Fields{"error": err})
+			return err
+		}
+	}
+
+	moves, unplaced, err := client.PlanReorganize()
+	if err != nil {
+		fa.Log.Error("Failed to plan reorganize", fa.Fields{"error": err})
+		return err
+	}
+	for _, image := range unplaced {
+		fa.Log.Warn("Not moving image, path template needs metadata that was never stored", fa.PageFields(image.PageURL).With(fa.Fields{"file": image.Filename, "missing": strings.Join(image.Missing, ",")}))
+	}
+	if len(unplaced) > 0 {
+		fa.Log.Warn("Skipped images without metadata", fa.Fields{"images": len(unplaced)})
+	}
+	if opts.Reorganize.DryRun {
+		for _, move := range moves {
+			fa.Log.Info("Would move image", fa.PageFields(move.PageURL).With(fa.Fields{"from": move.From, "to": move.To}))
+		}
+		fa.Log.Info("Dry run, nothing was moved", fa.Fields{"moves": len(moves)})
+		return nil
+	}
+
+	moved, err := client.Reorganize(ctx, moves)
+	if err != nil {
+		fa.Log.Error("Stopped reorganizing, run again to finish", fa.Fields{"moved": moved, "error": err})
+		return err
+	}
+	fa.Log.Info("Reorganized", fa.Fields{"moved": moved})
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/afurry/fadownloader/fa"
+)
+
+// verify reports problems with downloaded files and repairs them if asked
+// to. Images missing or changed that weren't repaired make it return
+// ErrVerifyFailed, orphans don't.
+func verify(client *fa.Client) error {
+	fa.Log.Info("Verifying", fa.Fields{"dir": opts.DownloadDirectory})
+	problems, err := client.Verify()
+	if err != nil {
+		fa.Log.Error("Failed to verify", fa.Fields{"error": err})
+		return err
+	}
+	counts := map[string]int{}
+	repaired := 0
+	unrepaired := 0
+	for _, problem := range problems {
+		counts[problem.Kind]++
+		fields := fa.Fields{"problem": problem.Kind, "file": problem.Filename}
+		if problem.Detail != "" {
+			fields["detail"] = problem.Detail
+		}
+		fa.Log.Warn("Found problem", fields)
+		if problem.Kind == fa.ProblemOrphan {
+			continue
+		}
+		if !opts.Verify.Repair {
+			unrepaired++
+			continue
+		}
+		err = client.Repair(problem)
+		if err != nil {
+			fa.Log.Error("Failed to repair", fields.With(fa.Fields{"error": err}))
+			unrepaired++
+			continue
+		}
+		repaired++
+	}
+	fields := fa.Fields{
+		"missing":         counts[fa.ProblemMissing],
+		"size_mismatches": counts[fa.ProblemSize],
+		"hash_mismatches": counts[fa.ProblemHash],
+		"orphans":         counts[fa.ProblemOrphan],
+	}
+	if opts.Verify.Repair {
+		// repaired images are downloaded by retry-failed
+		fields["repaired"] = repaired
+	}
+	fa.Log.Info("Verified", fields)
+	if unrepaired > 0 {
+		return fmt.Errorf("%d images missing or changed: %w", unrepaired, ErrVerifyFailed)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/afurry/fadownloader/fa"
+	"golang.org/x/net/html"
+)
+
+// watchlist downloads new submissions from watchlist. Submissions stay in
+// the watchlist, so it's loaded again only while a pass downloads something,
+// to catch what was posted in the meantime. It stops with *fa.PageError when
+// FA won't show the watchlist, like when session has expired, and with
+// fa.ErrPartialFailure when some images failed.
+func watchlist(stop, abort context.Context, client *fa.Client) error {
+	watchlistPage := client.BaseURL() + "/msg/submissions/"
+	summary := &runSummary{}
+	defer summary.print()
+	display.track(summary)
+
+	for stop.Err() == nil {
+		fa.Log.Debug("Loading watchlist submissions", fa.Fields{"url": watchlistPage})
+		display.scanning("Loading watchlist")
+		err := client.Open(stop, watchlistPage)
+		if err != nil {
+			fa.Log.Error("Failed to load watchlist", fa.Fields{"url": watchlistPage, "error": err})
+			return err
+		}
+		atomic.AddInt64(&summary.scanned, 1)
+		downloaded := atomic.LoadInt64(&summary.downloaded)
+
+		inputs := client.Browser.Find("#messagecenter-submissions label input")
+		imageIDs := []string{}
+		for _, input := range inputs.Nodes {
+			attrMap := attr2map(input.Attr)
+			if attrMap["type"] != "checkbox" {
+				continue
+			}
+			if attrMap["name"] != "submissions[]" {
+				continue
+			}
+			imageID, ok := attrMap["value"]
+			if !ok {
+				continue
+			}
+			imageIDs = append(imageIDs, imageID)
+		}
+
+		fa.Log.Info("Scanned watchlist page", fa.Fields{"images": len(imageIDs)})
+		display.scanning("Downloading %d images from watchlist", len(imageIDs))
+		if len(imageIDs) == 0 {
+			fa.Log.Info("No new submissions in watchlist", nil)
+			return summary.err()
+		}
+
+		for _, imageID := range imageIDs {
+			if stop.Err() != nil {
+				break
+			}
+			rawurl := fmt.Sprintf("%s/view/%s", client.BaseURL(), imageID)
+			imagePageURL, err := url.Parse(rawurl)
+			if err != nil {
+				fa.Log.Error("Failed to parse URL", fa.Fields{"url": rawurl, "error": err})
+				continue
+			}
+			fields := fa.PageFields(imagePageURL)
+			// check if it's in db and skip if it is
+			isDownloaded, err := client.IsDownloaded(imagePageURL)
+			if err != nil {
+				fa.Log.Warn("Failed querying database, will download anyway", fields.With(fa.Fields{"error": err}))
+			}
+			if isDownloaded {
+				fa.Log.Info("Skipped, already in database", fields)
+				atomic.AddInt64(&summary.skipped, 1)
+				continue
+			}
+			sub, err := client.Submission(stop, imagePageURL)
+			var pageErr *fa.PageError
+			if errors.As(err, &pageErr) && pageErr.Fatal() {
+				fa.Log.Error("Failed to load submission, stopping", fields.With(fa.Fields{"error": err}))
+				return err
+			}
+			if err != nil && stop.Err() != nil {
+				// interrupted while loading, not the submission's fault
+				break
+			}
+			if err != nil {
+				fa.Log.Error("Failed to load submission, skipping", fields.With(fa.Fields{"error": err}))
+				summary.fail(err)
+				continue
+			}
+			fields["artist"] = sub.Artist
+			sub.PageType = "watchlist"
+			atomic.AddInt64(&summary.queued, 1)
+
+			fa.Log.Debug("Downloading image", fields.With(fa.Fields{"url": sub.ImageURL}))
+			result, err := client.Download(abort, sub)
+			if err != nil {
+				fa.Log.Error("Failed to download image", fields.With(fa.Fields{"url": sub.ImageURL, "error": err}))
+				if abort.Err() == nil {
+					summary.fail(err)
+				}
+			} else {
+				fields = fields.With(fa.Fields{"file": result.Filename, "bytes": result.Size})
+				if result.Skipped {
+					fa.Log.Info("Skipped, file already exists and size matches", fields)
+					atomic.AddInt64(&summary.skipped, 1)
+				} else {
+					if result.Resumed > 0 {
+						fields["resumed"] = result.Resumed
+					}
+					fa.Log.Info("Saved image", fields)
+					atomic.AddInt64(&summary.downloaded, 1)
+				}
+			}
+		}
+		if atomic.LoadInt64(&summary.downloaded) == downloaded {
+			fa.Log.Info("Nothing new downloaded from watchlist", nil)
+			break
+		}
+	}
+	return summary.err()
+}
+
+func attr2map(attr []html.Attribute) map[string]string {
+	attrMap := map[string]string{}
+	for _, attribute := range attr {
+		if attribute.Namespace != "" {
+			continue
+		}
+		attrMap[strings.ToLower(attribute.Key)] = attribute.Val
+	}
+	return attrMap
+}